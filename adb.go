@@ -1,6 +1,7 @@
 package adb
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -37,7 +38,12 @@ func NewWithConfig(config ServerConfig) (*Adb, error) {
 
 // Dial establishes a connection with the adb server.
 func (c *Adb) Dial() (*wire.Conn, error) {
-	return c.server.Dial()
+	return c.DialContext(context.Background())
+}
+
+// DialContext is like Dial, but the connection is closed when ctx is done.
+func (c *Adb) DialContext(ctx context.Context) (*wire.Conn, error) {
+	return c.server.Dial(ctx)
 }
 
 // Starts the adb server if it’s not running.
@@ -49,17 +55,28 @@ func (c *Adb) Device(descriptor DeviceDescriptor) *Device {
 	return &Device{
 		server:         c.server,
 		descriptor:     descriptor,
-		deviceListFunc: c.ListDevices,
+		deviceListFunc: c.ListDevicesContext,
 	}
 }
 
+// NewDeviceWatcher starts watching for device state changes using the default
+// DeviceWatcherConfig. Call Shutdown on the returned watcher when done with it.
 func (c *Adb) NewDeviceWatcher() *DeviceWatcher {
-	return newDeviceWatcher(c.server)
+	return c.NewDeviceWatcherWithConfig(DeviceWatcherConfig{})
+}
+
+func (c *Adb) NewDeviceWatcherWithConfig(config DeviceWatcherConfig) *DeviceWatcher {
+	return newDeviceWatcher(c.server, config)
 }
 
 // ServerVersion asks the ADB server for its internal version number.
 func (c *Adb) ServerVersion() (int, error) {
-	resp, err := roundTripSingleResponse(c.server, "host:version")
+	return c.ServerVersionContext(context.Background())
+}
+
+// ServerVersionContext is like ServerVersion, but gives up when ctx is done.
+func (c *Adb) ServerVersionContext(ctx context.Context) (int, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:version")
 	if err != nil {
 		return 0, wrapClientError(err, c, "GetServerVersion")
 	}
@@ -79,7 +96,12 @@ Corresponds to the command:
 	adb kill-server
 */
 func (c *Adb) KillServer() error {
-	conn, err := c.server.Dial()
+	return c.KillServerContext(context.Background())
+}
+
+// KillServerContext is like KillServer, but gives up when ctx is done.
+func (c *Adb) KillServerContext(ctx context.Context) error {
+	conn, err := c.server.Dial(ctx)
 	if err != nil {
 		return wrapClientError(err, c, "KillServer")
 	}
@@ -100,7 +122,12 @@ Corresponds to the command:
 	adb devices
 */
 func (c *Adb) ListDeviceSerials() ([]string, error) {
-	resp, err := roundTripSingleResponse(c.server, "host:devices")
+	return c.ListDeviceSerialsContext(context.Background())
+}
+
+// ListDeviceSerialsContext is like ListDeviceSerials, but gives up when ctx is done.
+func (c *Adb) ListDeviceSerialsContext(ctx context.Context) ([]string, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:devices")
 	if err != nil {
 		return nil, wrapClientError(err, c, "ListDeviceSerials")
 	}
@@ -125,7 +152,12 @@ Corresponds to the command:
 	adb devices -l
 */
 func (c *Adb) ListDevices() ([]*DeviceInfo, error) {
-	resp, err := roundTripSingleResponse(c.server, "host:devices-l")
+	return c.ListDevicesContext(context.Background())
+}
+
+// ListDevicesContext is like ListDevices, but gives up when ctx is done.
+func (c *Adb) ListDevicesContext(ctx context.Context) ([]*DeviceInfo, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:devices-l")
 	if err != nil {
 		return nil, wrapClientError(err, c, "ListDevices")
 	}
@@ -145,7 +177,12 @@ Corresponds to the command:
 	adb connect
 */
 func (c *Adb) Connect(host string, port int) error {
-	_, err := roundTripSingleResponse(c.server, fmt.Sprintf("host:connect:%s:%d", host, port))
+	return c.ConnectContext(context.Background(), host, port)
+}
+
+// ConnectContext is like Connect, but gives up when ctx is done.
+func (c *Adb) ConnectContext(ctx context.Context, host string, port int) error {
+	_, err := roundTripSingleResponse(ctx, c.server, fmt.Sprintf("host:connect:%s:%d", host, port))
 	if err != nil {
 		return wrapClientError(err, c, "Connect")
 	}
@@ -153,7 +190,12 @@ func (c *Adb) Connect(host string, port int) error {
 }
 
 func (c *Adb) DisConnect(host string, port int) error {
-	_, err := roundTripSingleResponse(c.server, fmt.Sprintf("host:disconnect:%s:%d", host, port))
+	return c.DisConnectContext(context.Background(), host, port)
+}
+
+// DisConnectContext is like DisConnect, but gives up when ctx is done.
+func (c *Adb) DisConnectContext(ctx context.Context, host string, port int) error {
+	_, err := roundTripSingleResponse(ctx, c.server, fmt.Sprintf("host:disconnect:%s:%d", host, port))
 	if err != nil {
 		return wrapClientError(err, c, "DisConnect")
 	}
@@ -1,10 +1,13 @@
 package adb
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/drtechco/goadb/wire"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetServerVersion(t *testing.T) {
@@ -19,3 +22,25 @@ func TestGetServerVersion(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 10, v)
 }
+
+func TestListDevicesManyDevices(t *testing.T) {
+	var lines []string
+	for i := 0; i < 64; i++ {
+		lines = append(lines, fmt.Sprintf(
+			"%08x               device usb:1-%d product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64x transport_id:%d\n",
+			i, i, i+1))
+	}
+	response := strings.Join(lines, "")
+	require.True(t, len(response) > 4096)
+	s, requests := newResponseServer(response)
+	client := &Adb{s}
+
+	devices, err := client.ListDevices()
+	require.NoError(t, err)
+	assert.Equal(t, "host:devices-l", <-requests)
+	require.Len(t, devices, 64)
+	assert.Equal(t, "0000003f", devices[63].Serial)
+	assert.Equal(t, "1-63", devices[63].Usb)
+	assert.Equal(t, "emu64x", devices[63].DeviceInfo)
+	assert.Equal(t, int64(64), devices[63].TransportID)
+}
@@ -2,12 +2,15 @@ package adb
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"github.com/zach-klippenstein/goadb/internal/errors"
 	"github.com/zach-klippenstein/goadb/wire"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,7 +25,11 @@ type Device struct {
 	descriptor DeviceDescriptor
 
 	// Used to get device info.
-	deviceListFunc func() ([]*DeviceInfo, error)
+	deviceListFunc func(ctx context.Context) ([]*DeviceInfo, error)
+
+	featuresLock sync.Mutex
+	// The device's features, or nil if they haven't been fetched since it last disconnected.
+	cachedFeatures FeatureSet
 }
 
 func (c *Device) String() string {
@@ -31,26 +38,49 @@ func (c *Device) String() string {
 
 // get-product is documented, but not implemented, in the server.
 // TODO(z): Make product exported if get-product is ever implemented in adb.
-func (c *Device) product() (string, error) {
-	attr, err := c.getAttribute("get-product")
+func (c *Device) product(ctx context.Context) (string, error) {
+	attr, err := c.getAttribute(ctx, "get-product")
 	return attr, wrapClientError(err, c, "Product")
 }
 
 func (c *Device) Serial() (string, error) {
-	attr, err := c.getAttribute("get-serialno")
+	return c.SerialContext(context.Background())
+}
+
+// SerialContext is like Serial, but gives up when ctx is done.
+func (c *Device) SerialContext(ctx context.Context) (string, error) {
+	attr, err := c.getAttribute(ctx, "get-serialno")
 	return attr, wrapClientError(err, c, "Serial")
 }
 
 func (c *Device) DevicePath() (string, error) {
-	attr, err := c.getAttribute("get-devpath")
+	return c.DevicePathContext(context.Background())
+}
+
+// DevicePathContext is like DevicePath, but gives up when ctx is done.
+func (c *Device) DevicePathContext(ctx context.Context) (string, error) {
+	attr, err := c.getAttribute(ctx, "get-devpath")
 	return attr, wrapClientError(err, c, "DevicePath")
 }
 
 func (c *Device) State() (DeviceState, error) {
-	attr, err := c.getAttribute("get-state")
+	return c.StateContext(context.Background())
+}
+
+// StateContext is like State, but gives up when ctx is done.
+func (c *Device) StateContext(ctx context.Context) (DeviceState, error) {
+	attr, err := c.getAttribute(ctx, "get-state")
 	if err != nil {
-		if strings.Contains(err.Error(), "unauthorized") {
+		// The server fails requests for devices it can't talk to, rather than reporting their state.
+		switch msg := err.Error(); {
+		case strings.Contains(msg, "unauthorized"):
 			return StateUnauthorized, nil
+		case strings.Contains(msg, "insufficient permissions"):
+			return StateNoPermissions, nil
+		case strings.Contains(msg, "still authorizing"):
+			return StateAuthorizing, nil
+		case strings.Contains(msg, "still connecting"):
+			return StateConnecting, nil
 		}
 		return StateInvalid, wrapClientError(err, c, "State")
 	}
@@ -59,15 +89,20 @@ func (c *Device) State() (DeviceState, error) {
 }
 
 func (c *Device) DeviceInfo() (*DeviceInfo, error) {
+	return c.DeviceInfoContext(context.Background())
+}
+
+// DeviceInfoContext is like DeviceInfo, but gives up when ctx is done.
+func (c *Device) DeviceInfoContext(ctx context.Context) (*DeviceInfo, error) {
 	// Adb doesn't actually provide a way to get this for an individual device,
 	// so we have to just list devices and find ourselves.
 
-	serial, err := c.Serial()
+	serial, err := c.SerialContext(ctx)
 	if err != nil {
 		return nil, wrapClientError(err, c, "GetDeviceInfo(GetSerial)")
 	}
 
-	devices, err := c.deviceListFunc()
+	devices, err := c.deviceListFunc(ctx)
 	if err != nil {
 		return nil, wrapClientError(err, c, "DeviceInfo(ListDevices)")
 	}
@@ -101,12 +136,17 @@ This method quotes the arguments for you, and will return an error if any of the
 contain double quotes.
 */
 func (c *Device) RunCommand(cmd string, args ...string) (string, error) {
+	return c.RunCommandContext(context.Background(), cmd, args...)
+}
+
+// RunCommandContext is like RunCommand, but the connection is closed when ctx is done.
+func (c *Device) RunCommandContext(ctx context.Context, cmd string, args ...string) (string, error) {
 	cmd, err := prepareCommandLine(cmd, args...)
 	if err != nil {
 		return "", wrapClientError(err, c, "RunCommand")
 	}
 
-	conn, err := c.dialDevice()
+	conn, err := c.dialDevice(ctx)
 	if err != nil {
 		return "", wrapClientError(err, c, "RunCommand")
 	}
@@ -128,12 +168,21 @@ func (c *Device) RunCommand(cmd string, args ...string) (string, error) {
 	return string(resp), wrapClientError(err, c, "RunCommand")
 }
 
+/*
+RunCommandV2 runs the specified command on the device and returns its exit code, stdout and stderr.
+See RunCommandV2WithStd.
+*/
 func (c *Device) RunCommandV2(cmd string, args ...string) (int, string, string, error) {
+	return c.RunCommandV2Context(context.Background(), cmd, args...)
+}
+
+// RunCommandV2Context is like RunCommandV2, but the connection is closed when ctx is done.
+func (c *Device) RunCommandV2Context(ctx context.Context, cmd string, args ...string) (int, string, string, error) {
 	// Create buffers to capture stdout and stderr
 	var stdoutBuf, stderrBuf bytes.Buffer
 
 	// Run the command with our buffer writers
-	exitCode, err := c.RunCommandV2WithStd(&stdoutBuf, &stderrBuf, cmd, args...)
+	exitCode, err := c.RunCommandV2WithStdContext(ctx, &stdoutBuf, &stderrBuf, cmd, args...)
 
 	// Return the captured output and any error
 	// Note: We return the string output regardless of error status
@@ -142,7 +191,12 @@ func (c *Device) RunCommandV2(cmd string, args ...string) (int, string, string,
 }
 
 func (c *Device) Root() (string, error) {
-	conn, err := c.dialDevice()
+	return c.RootContext(context.Background())
+}
+
+// RootContext is like Root, but gives up when ctx is done.
+func (c *Device) RootContext(ctx context.Context) (string, error) {
+	conn, err := c.dialDevice(ctx)
 	if err != nil {
 		return "", wrapClientError(err, c, "Root")
 	}
@@ -153,13 +207,38 @@ func (c *Device) Root() (string, error) {
 
 }
 
+/*
+RunCommandV2WithStd runs the specified command on the device, writes its output to stdout and
+stderr as it's received, and returns its exit code.
+
+Devices without the shell_v2 feature, from before Android 7.0, can't report stderr separately, so
+it's merged into stdout and nothing is written to stderr.
+*/
 func (c *Device) RunCommandV2WithStd(stdout io.Writer, stderr io.Writer, cmd string, args ...string) (int, error) {
+	return c.RunCommandV2WithStdContext(context.Background(), stdout, stderr, cmd, args...)
+}
+
+// RunCommandV2WithStdContext is like RunCommandV2WithStd, but the connection is closed when ctx
+// is done.
+func (c *Device) RunCommandV2WithStdContext(ctx context.Context, stdout io.Writer, stderr io.Writer, cmd string, args ...string) (int, error) {
 	cmd, err := prepareCommandLine(cmd, args...)
 	if err != nil {
 		return -1, wrapClientError(err, c, "RunCommand")
 	}
 
-	conn, err := c.dialDevice()
+	features, err := c.features(ctx)
+	if err != nil {
+		return -1, wrapClientError(err, c, "RunCommand")
+	}
+	if !features.Has(FeatureShell2) {
+		exitCode, err := c.streamWithStatus(ctx, cmd, stdout)
+		if err != nil {
+			return -1, wrapClientError(err, c, "RunCommand")
+		}
+		return exitCode, nil
+	}
+
+	conn, err := c.dialDevice(ctx)
 	if err != nil {
 		return -1, wrapClientError(err, c, "RunCommand")
 	}
@@ -180,6 +259,7 @@ func (c *Device) RunCommandV2WithStd(stdout io.Writer, stderr io.Writer, cmd str
 	}
 
 	exitCode, err := conn.ReadUntilEofV2WithStd(stdout, stderr)
+	c.forgetFeaturesAfter(err)
 	return exitCode, err
 }
 
@@ -195,7 +275,12 @@ Remount, from the official adb command’s docs:
 Source: https://android.googlesource.com/platform/system/core/+/master/adb/SERVICES.TXT
 */
 func (c *Device) Remount() (string, error) {
-	conn, err := c.dialDevice()
+	return c.RemountContext(context.Background())
+}
+
+// RemountContext is like Remount, but gives up when ctx is done.
+func (c *Device) RemountContext(ctx context.Context) (string, error) {
+	conn, err := c.dialDevice(ctx)
 	if err != nil {
 		return "", wrapClientError(err, c, "Remount")
 	}
@@ -206,54 +291,64 @@ func (c *Device) Remount() (string, error) {
 }
 
 func (c *Device) ListDirEntries(path string) (*DirEntries, error) {
-	conn, err := c.getSyncConn()
+	return c.ListDirEntriesContext(context.Background(), path)
+}
+
+// ListDirEntriesContext is like ListDirEntries, but the connection is closed when ctx is done.
+func (c *Device) ListDirEntriesContext(ctx context.Context, path string) (*DirEntries, error) {
+	conn, proto, err := c.getSyncConn(ctx)
 	if err != nil {
 		return nil, wrapClientError(err, c, "ListDirEntries(%s)", path)
 	}
 
-	entries, err := listDirEntries(conn, path)
+	entries, err := listDirEntries(conn, path, proto)
 	return entries, wrapClientError(err, c, "ListDirEntries(%s)", path)
 }
 
 func (c *Device) Stat(path string) (*DirEntry, error) {
-	conn, err := c.getSyncConn()
+	return c.StatContext(context.Background(), path)
+}
+
+// StatContext is like Stat, but gives up when ctx is done.
+func (c *Device) StatContext(ctx context.Context, path string) (*DirEntry, error) {
+	conn, proto, err := c.getSyncConn(ctx)
 	if err != nil {
 		return nil, wrapClientError(err, c, "Stat(%s)", path)
 	}
 	defer conn.Close()
 
-	entry, err := stat(conn, path)
+	entry, err := stat(conn, path, proto)
 	return entry, wrapClientError(err, c, "Stat(%s)", path)
 }
 
 func (c *Device) OpenRead(path string) (io.ReadCloser, error) {
-	conn, err := c.getSyncConn()
-	if err != nil {
-		return nil, wrapClientError(err, c, "OpenRead(%s)", path)
-	}
+	return c.OpenReadContext(context.Background(), path)
+}
 
-	reader, err := receiveFile(conn, path)
-	return reader, wrapClientError(err, c, "OpenRead(%s)", path)
+// OpenReadContext is like OpenRead, but the connection is closed when ctx is done.
+func (c *Device) OpenReadContext(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.OpenReadWithOptions(ctx, path, TransferOptions{})
 }
 
 // OpenWrite opens the file at path on the device, creating it with the permissions specified
 // by perms if necessary, and returns a writer that writes to the file.
 // The files modification time will be set to mtime when the WriterCloser is closed. The zero value
 // is TimeOfClose, which will use the time the Close method is called as the modification time.
+// Close waits for the device to finish writing the file, and returns an error if it couldn't, e.g.
+// because it ran out of space.
 func (c *Device) OpenWrite(path string, perms os.FileMode, mtime time.Time) (io.WriteCloser, error) {
-	conn, err := c.getSyncConn()
-	if err != nil {
-		return nil, wrapClientError(err, c, "OpenWrite(%s)", path)
-	}
+	return c.OpenWriteContext(context.Background(), path, perms, mtime)
+}
 
-	writer, err := sendFile(conn, path, perms, mtime)
-	return writer, wrapClientError(err, c, "OpenWrite(%s)", path)
+// OpenWriteContext is like OpenWrite, but the connection is closed when ctx is done.
+func (c *Device) OpenWriteContext(ctx context.Context, path string, perms os.FileMode, mtime time.Time) (io.WriteCloser, error) {
+	return c.OpenWriteWithOptions(ctx, path, perms, mtime, TransferOptions{})
 }
 
 // getAttribute returns the first message returned by the server by running
 // <host-prefix>:<attr>, where host-prefix is determined from the DeviceDescriptor.
-func (c *Device) getAttribute(attr string) (string, error) {
-	resp, err := roundTripSingleResponse(c.server,
+func (c *Device) getAttribute(ctx context.Context, attr string) (string, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server,
 		fmt.Sprintf("%s:%s", c.descriptor.getHostPrefix(), attr))
 	if err != nil {
 		return "", err
@@ -261,45 +356,108 @@ func (c *Device) getAttribute(attr string) (string, error) {
 	return string(resp), nil
 }
 
-func (c *Device) getSyncConn() (*wire.SyncConn, error) {
-	conn, err := c.dialDevice()
+// getSyncConn returns a connection in sync mode, and the versions of the sync messages the device
+// supports.
+func (c *Device) getSyncConn(ctx context.Context) (*wire.SyncConn, syncProtocol, error) {
+	features, err := c.features(ctx)
 	if err != nil {
-		return nil, err
+		return nil, syncProtocol{}, err
+	}
+
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return nil, syncProtocol{}, err
 	}
 
 	// Switch the connection to sync mode.
 	if err := wire.SendMessageString(conn, "sync:"); err != nil {
-		return nil, err
+		conn.Close()
+		c.forgetFeaturesAfter(err)
+		return nil, syncProtocol{}, err
 	}
 	if _, err := conn.ReadStatus("sync"); err != nil {
-		return nil, err
+		conn.Close()
+		c.forgetFeaturesAfter(err)
+		return nil, syncProtocol{}, err
 	}
 
-	return conn.NewSyncConn(), nil
+	return conn.NewSyncConn(), newSyncProtocol(features), nil
 }
 
 // dialDevice switches the connection to communicate directly with the device
 // by requesting the transport defined by the DeviceDescriptor.
-func (c *Device) dialDevice() (*wire.Conn, error) {
-	conn, err := c.server.Dial()
+// The connection is closed when ctx is done.
+func (c *Device) dialDevice(ctx context.Context) (*wire.Conn, error) {
+	conn, err := c.server.Dial(ctx)
 	if err != nil {
+		c.forgetFeaturesAfter(err)
 		return nil, err
 	}
 
 	req := fmt.Sprintf("host:%s", c.descriptor.getTransportDescriptor())
 	if err = wire.SendMessageString(conn, req); err != nil {
 		conn.Close()
+		c.forgetFeaturesAfter(err)
 		return nil, errors.WrapErrf(err, "error connecting to device '%s'", c.descriptor)
 	}
 
 	if _, err = conn.ReadStatus(req); err != nil {
 		conn.Close()
+		// The device is offline or gone, and may have different features when it's back.
+		c.forgetFeatures()
 		return nil, err
 	}
 
 	return conn, nil
 }
 
+/*
+TransportID returns the ID of the transport that the device is connected by. Passing it to
+DeviceWithTransportID selects the same device, even if another one with the same serial connects.
+It requires an adb server that supports transport IDs.
+*/
+func (c *Device) TransportID() (int64, error) {
+	return c.TransportIDContext(context.Background())
+}
+
+// TransportIDContext is like TransportID, but gives up when ctx is done.
+func (c *Device) TransportIDContext(ctx context.Context) (int64, error) {
+	id, err := c.transportID(ctx)
+	return id, wrapClientError(err, c, "TransportID")
+}
+
+func (c *Device) transportID(ctx context.Context) (int64, error) {
+	if c.descriptor.descriptorType == DeviceTransportID {
+		return c.descriptor.transportID, nil
+	}
+
+	conn, err := c.server.Dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return requestTport(conn, c.descriptor)
+}
+
+// requestTport switches conn to the device's transport, like dialDevice, and returns the ID of
+// the transport. The server sends the ID as a 64-bit little-endian integer after the status.
+func requestTport(conn *wire.Conn, descriptor DeviceDescriptor) (int64, error) {
+	req := fmt.Sprintf("host:%s", descriptor.getTportDescriptor())
+	if err := wire.SendMessageString(conn, req); err != nil {
+		return 0, errors.WrapErrf(err, "error connecting to device '%s'", descriptor)
+	}
+	if _, err := conn.ReadStatus(req); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	if err := binary.Read(conn, binary.LittleEndian, &id); err != nil {
+		return 0, errors.WrapErrorf(err, errors.NetworkError, "error reading transport ID")
+	}
+	return id, nil
+}
+
 // prepareCommandLine validates the command and argument strings, quotes
 // arguments if required, and joins them into a valid adb command string.
 func prepareCommandLine(cmd string, args ...string) (string, error) {
@@ -323,42 +481,45 @@ func prepareCommandLine(cmd string, args ...string) (string, error) {
 }
 
 func (c *Device) Pull(remotePath string, localFile io.Writer) error {
-	if remotePath == "" {
-		return errors.Errorf(errors.AssertionError, "remotePath cannot be empty")
-	}
-	if localFile == nil {
-		return errors.Errorf(errors.AssertionError, "localFile cannot be nil")
-	}
-	info, err := c.Stat(remotePath)
-	if err != nil {
-		return err
-	}
-	remoteFile, err := c.OpenRead(remotePath)
-	if err != nil {
-		return err
-	}
-	defer remoteFile.Close()
-	if _, err := io.CopyN(localFile, remoteFile, int64(info.Size)); err != nil {
-		return err
-	}
-	return nil
+	return c.PullContext(context.Background(), remotePath, localFile)
+}
+
+// PullContext is like Pull, but the transfer is aborted when ctx is done.
+func (c *Device) PullContext(ctx context.Context, remotePath string, localFile io.Writer) error {
+	return c.PullWithOptions(ctx, remotePath, localFile, PullOptions{})
 }
 
+// Push writes the contents of localFile to remotePath on the device, with permissions 0666. Use
+// PushWithOptions to set other attributes, or PushFile to copy them from a local file.
 func (c *Device) Push(localFile io.Reader, remotePath string) error {
-	if remotePath == "" {
-		return errors.Errorf(errors.AssertionError, "remotePath cannot be empty")
-	}
-	if localFile == nil {
-		return errors.Errorf(errors.AssertionError, "localFile cannot be nil")
-	}
-	mtime := time.Now()
-	writer, err := c.OpenWrite(remotePath, os.FileMode(0x666), mtime)
+	return c.PushContext(context.Background(), localFile, remotePath)
+}
+
+// PushContext is like Push, but the transfer is aborted when ctx is done.
+func (c *Device) PushContext(ctx context.Context, localFile io.Reader, remotePath string) error {
+	return c.PushWithOptions(ctx, localFile, remotePath, PushOptions{})
+}
+
+// PushFile pushes the file at localPath to remotePath on the device, with the same permissions
+// and modification time.
+func (c *Device) PushFile(localPath, remotePath string) error {
+	return c.PushFileContext(context.Background(), localPath, remotePath)
+}
+
+// PushFileContext is like PushFile, but the transfer is aborted when ctx is done.
+func (c *Device) PushFileContext(ctx context.Context, localPath, remotePath string) error {
+	return c.PushFileWithOptions(ctx, localPath, remotePath, PushOptions{})
+}
+
+// PushFileWithOptions is like PushFileContext, but transfers the file as configured by opts. The
+// local file's attributes are used unless opts sets them.
+func (c *Device) PushFileWithOptions(ctx context.Context, localPath, remotePath string, opts PushOptions) error {
+	file, err := os.Open(localPath)
 	if err != nil {
 		return err
 	}
-	defer writer.Close()
-	if _, err := io.Copy(writer, localFile); err != nil {
-		return err
-	}
-	return nil
+	defer file.Close()
+
+	opts.Preserve = true
+	return c.PushWithOptions(ctx, file, remotePath, opts)
 }
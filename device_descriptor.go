@@ -14,6 +14,8 @@ const (
 	DeviceUsb
 	// host:transport-local and host-local:<request>
 	DeviceLocal
+	// host:transport-id:<id> and host-transport-id:<id>:<request>
+	DeviceTransportID
 )
 
 type DeviceDescriptor struct {
@@ -21,6 +23,9 @@ type DeviceDescriptor struct {
 
 	// Only used if Type is DeviceSerial.
 	serial string
+
+	// Only used if Type is DeviceTransportID.
+	transportID int64
 }
 
 func AnyDevice() DeviceDescriptor {
@@ -42,9 +47,23 @@ func DeviceWithSerial(serial string) DeviceDescriptor {
 	}
 }
 
+// DeviceWithTransportID selects the device connected by the transport with the given ID, as
+// reported by DeviceInfo.TransportID or Device.TransportID. Unlike serials, transport IDs are
+// unique, even if two devices report the same serial. A device gets a new transport ID each time
+// it connects.
+func DeviceWithTransportID(id int64) DeviceDescriptor {
+	return DeviceDescriptor{
+		descriptorType: DeviceTransportID,
+		transportID:    id,
+	}
+}
+
 func (d DeviceDescriptor) String() string {
-	if d.descriptorType == DeviceSerial {
+	switch d.descriptorType {
+	case DeviceSerial:
 		return fmt.Sprintf("%s[%s]", d.descriptorType, d.serial)
+	case DeviceTransportID:
+		return fmt.Sprintf("%s[%d]", d.descriptorType, d.transportID)
 	}
 	return d.descriptorType.String()
 }
@@ -59,6 +78,8 @@ func (d DeviceDescriptor) getHostPrefix() string {
 		return "host-local"
 	case DeviceSerial:
 		return fmt.Sprintf("host-serial:%s", d.serial)
+	case DeviceTransportID:
+		return fmt.Sprintf("host-transport-id:%d", d.transportID)
 	default:
 		panic(fmt.Sprintf("invalid DeviceDescriptorType: %v", d.descriptorType))
 	}
@@ -74,7 +95,27 @@ func (d DeviceDescriptor) getTransportDescriptor() string {
 		return "transport-local"
 	case DeviceSerial:
 		return fmt.Sprintf("transport:%s", d.serial)
+	case DeviceTransportID:
+		return fmt.Sprintf("transport-id:%d", d.transportID)
 	default:
 		panic(fmt.Sprintf("invalid DeviceDescriptorType: %v", d.descriptorType))
 	}
 }
+
+// getTportDescriptor returns the tport request that selects the same device as
+// getTransportDescriptor, and also reports the ID of the transport. Descriptors with a transport
+// ID don't have one, since the ID is already known.
+func (d DeviceDescriptor) getTportDescriptor() string {
+	switch d.descriptorType {
+	case DeviceAny:
+		return "tport:any"
+	case DeviceUsb:
+		return "tport:usb"
+	case DeviceLocal:
+		return "tport:local"
+	case DeviceSerial:
+		return fmt.Sprintf("tport:serial:%s", d.serial)
+	default:
+		panic(fmt.Sprintf("invalid DeviceDescriptorType for tport: %v", d.descriptorType))
+	}
+}
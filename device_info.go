@@ -2,6 +2,7 @@ package adb
 
 import (
 	"bufio"
+	"strconv"
 	"strings"
 
 	"github.com/zach-klippenstein/goadb/internal/errors"
@@ -18,6 +19,10 @@ type DeviceInfo struct {
 
 	// Only set for devices connected via USB.
 	Usb string
+
+	// The ID of the transport the device is connected by, which can be passed to
+	// DeviceWithTransportID. Only set in the long form, by adb servers that report it.
+	TransportID int64
 }
 
 // IsUsb returns true if the device is connected via USB.
@@ -30,12 +35,16 @@ func newDevice(serial string, attrs map[string]string) (*DeviceInfo, error) {
 		return nil, errors.AssertionErrorf("device serial cannot be blank")
 	}
 
+	// Servers that don't report transport IDs leave it 0, which is never a valid ID.
+	transportID, _ := strconv.ParseInt(attrs["transport_id"], 10, 64)
+
 	return &DeviceInfo{
-		Serial:     serial,
-		Product:    attrs["product"],
-		Model:      attrs["model"],
-		DeviceInfo: attrs["device"],
-		Usb:        attrs["usb"],
+		Serial:      serial,
+		Product:     attrs["product"],
+		Model:       attrs["model"],
+		DeviceInfo:  attrs["device"],
+		Usb:         attrs["usb"],
+		TransportID: transportID,
 	}, nil
 }
 
@@ -55,20 +64,54 @@ func parseDeviceList(list string, lineParseFunc func(string) (*DeviceInfo, error
 }
 
 func parseDeviceShort(line string) (*DeviceInfo, error) {
-	fields := strings.Fields(line)
+	// The serial and state are separated by a tab, since the state may contain spaces.
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
 	if len(fields) != 2 {
 		return nil, errors.Errorf(errors.ParseError,
 			"malformed device line, expected 2 fields but found %d", len(fields))
 	}
 
-	return newDevice(fields[0], map[string]string{})
+	return newDevice(strings.TrimSpace(fields[0]), map[string]string{})
 }
 
 func parseDeviceLong(line string) (*DeviceInfo, error) {
+	serial, _, attrs, err := splitDeviceLong(line)
+	if err != nil {
+		return nil, err
+	}
+	return newDevice(serial, parseDeviceAttributes(attrs))
+}
+
+// splitDeviceLong splits a line in the long form into the device's serial, its state, and its
+// key:value attributes. The state is everything between the serial and the attributes, since it
+// may contain spaces.
+func splitDeviceLong(line string) (serial, state string, attrs []string, err error) {
 	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", nil, errors.Errorf(errors.ParseError,
+			"malformed device line, expected at least 2 fields but found %d", len(fields))
+	}
 
-	attrs := parseDeviceAttributes(fields[2:])
-	return newDevice(fields[0], attrs)
+	attrsStart := len(fields)
+	for attrsStart > 2 && isDeviceAttribute(fields[attrsStart-1]) {
+		attrsStart--
+	}
+	return fields[0], strings.Join(fields[1:attrsStart], " "), fields[attrsStart:], nil
+}
+
+// isDeviceAttribute returns true if field is a key:value pair with a lowercase key, as opposed to
+// part of a state like "no permissions (...); see [http://...]".
+func isDeviceAttribute(field string) bool {
+	i := strings.IndexByte(field, ':')
+	if i <= 0 {
+		return false
+	}
+	for _, r := range field[:i] {
+		if (r < 'a' || r > 'z') && r != '_' {
+			return false
+		}
+	}
+	return true
 }
 
 func parseDeviceAttributes(fields []string) map[string]string {
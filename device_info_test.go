@@ -37,8 +37,9 @@ func TestParseDeviceLongUnauthorized(t *testing.T) {
 	dev, err := parseDeviceLong("SERIAL    unauthorized usb:1234 transport_id:8")
 	assert.NoError(t, err)
 	assert.Equal(t, &DeviceInfo{
-		Serial: "SERIAL",
-		Usb: "1234"}, dev)
+		Serial:      "SERIAL",
+		Usb:         "1234",
+		TransportID: 8}, dev)
 }
 
 func TestParseDeviceLongUsb(t *testing.T) {
@@ -51,3 +52,25 @@ func TestParseDeviceLongUsb(t *testing.T) {
 		DeviceInfo: "DEVICE",
 		Usb:        "1234"}, dev)
 }
+
+func TestParseDeviceShortNoPermissions(t *testing.T) {
+	dev, err := parseDeviceShort("0123456789ABCDEF\tno permissions (missing udev rules? user is in the plugdev group); " +
+		"see [http://developer.android.com/tools/device.html]")
+	assert.NoError(t, err)
+	assert.Equal(t, &DeviceInfo{Serial: "0123456789ABCDEF"}, dev)
+}
+
+func TestParseDeviceLongNoPermissions(t *testing.T) {
+	dev, err := parseDeviceLong("0123456789ABCDEF       no permissions (missing udev rules? user is in the plugdev group); " +
+		"see [http://developer.android.com/tools/device.html] usb:1-1 transport_id:3")
+	assert.NoError(t, err)
+	assert.Equal(t, &DeviceInfo{
+		Serial:      "0123456789ABCDEF",
+		Usb:         "1-1",
+		TransportID: 3}, dev)
+}
+
+func TestParseDeviceLongMalformed(t *testing.T) {
+	_, err := parseDeviceLong("SERIAL")
+	assert.True(t, HasErrCode(err, ParseError))
+}
@@ -1,14 +1,23 @@
 package adb
 
-import "github.com/zach-klippenstein/goadb/internal/errors"
+import (
+	"strings"
 
-// DeviceState represents one of the 3 possible states adb will report devices.
-// A device can be communicated with when it's in StateOnline.
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// DeviceState represents one of the states adb will report devices in.
+// A device can be communicated with when it's in StateOnline, and with a limited set of
+// services in StateRecovery, StateRescue and StateSideload.
 // A USB device will make the following state transitions:
 //
 //	Plugged in: StateDisconnected->StateOffline->StateOnline
 //	Unplugged:  StateOnline->StateDisconnected
 //
+// A device that hasn't authorized the host goes through StateAuthorizing and StateUnauthorized
+// instead, and one that the host doesn't have permission to open over USB stays in
+// StateNoPermissions.
+//
 //go:generate stringer -type=DeviceState
 type DeviceState int8
 
@@ -18,6 +27,22 @@ const (
 	StateDisconnected
 	StateOffline
 	StateOnline
+	// Booted into recovery.
+	StateRecovery
+	// Booted into rescue mode, which only accepts a few commands.
+	StateRescue
+	// Booted into recovery, waiting for "adb sideload".
+	StateSideload
+	// In the bootloader. Only reported by adb servers that support fastboot devices.
+	StateBootloader
+	// The device is checking whether the host's key is authorized.
+	StateAuthorizing
+	// The server is connecting to a network device.
+	StateConnecting
+	// The "device" is another adb host, connected over USB.
+	StateHost
+	// The host doesn't have permission to open the USB device, e.g. because of missing udev rules.
+	StateNoPermissions
 )
 
 var deviceStateStrings = map[string]DeviceState{
@@ -25,12 +50,28 @@ var deviceStateStrings = map[string]DeviceState{
 	"offline":      StateOffline,
 	"device":       StateOnline,
 	"unauthorized": StateUnauthorized,
+	"recovery":     StateRecovery,
+	"rescue":       StateRescue,
+	"sideload":     StateSideload,
+	"bootloader":   StateBootloader,
+	"authorizing":  StateAuthorizing,
+	"connecting":   StateConnecting,
+	"host":         StateHost,
 }
 
+// noPermissionsState is the start of the state adb reports for devices it can't open. It's
+// followed by the reason and a link to help, which both contain spaces, e.g.:
+//
+//	no permissions (missing udev rules? user is in the plugdev group); see [http://developer.android.com/tools/device.html]
+const noPermissionsState = "no permissions"
+
 func parseDeviceState(str string) (DeviceState, error) {
+	if strings.HasPrefix(str, noPermissionsState) {
+		return StateNoPermissions, nil
+	}
 	state, ok := deviceStateStrings[str]
 	if !ok {
-		return StateInvalid, errors.Errorf(errors.ParseError, "invalid device state: %q", state)
+		return StateInvalid, errors.Errorf(errors.ParseError, "invalid device state: %q", str)
 	}
 	return state, nil
 }
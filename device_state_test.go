@@ -18,7 +18,17 @@ func TestParseDeviceState(t *testing.T) {
 		{"offline", StateOffline, "StateOffline", nil},
 		{"device", StateOnline, "StateOnline", nil},
 		{"unauthorized", StateUnauthorized, "StateUnauthorized", nil},
-		{"bad", StateInvalid, "StateInvalid", errors.New(`ParseError: invalid device state: "StateInvalid"`)},
+		{"recovery", StateRecovery, "StateRecovery", nil},
+		{"rescue", StateRescue, "StateRescue", nil},
+		{"sideload", StateSideload, "StateSideload", nil},
+		{"bootloader", StateBootloader, "StateBootloader", nil},
+		{"authorizing", StateAuthorizing, "StateAuthorizing", nil},
+		{"connecting", StateConnecting, "StateConnecting", nil},
+		{"host", StateHost, "StateHost", nil},
+		{"no permissions", StateNoPermissions, "StateNoPermissions", nil},
+		{"no permissions (missing udev rules? user is in the plugdev group); see [http://developer.android.com/tools/device.html]",
+			StateNoPermissions, "StateNoPermissions", nil},
+		{"bad", StateInvalid, "StateInvalid", errors.New(`ParseError: invalid device state: "bad"`)},
 	} {
 		state, err := parseDeviceState(test.String)
 		if test.WantError == nil {
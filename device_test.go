@@ -1,6 +1,7 @@
 package adb
 
 import (
+	"context"
 	"testing"
 
 	"github.com/drtechco/goadb/internal/errors"
@@ -15,14 +16,14 @@ func TestGetAttribute(t *testing.T) {
 	}
 	client := (&Adb{s}).Device(DeviceWithSerial("serial"))
 
-	v, err := client.getAttribute("attr")
+	v, err := client.getAttribute(context.Background(), "attr")
 	assert.Equal(t, "host-serial:serial:attr", s.Requests[0])
 	assert.NoError(t, err)
 	assert.Equal(t, "value", v)
 }
 
 func TestGetDeviceInfo(t *testing.T) {
-	deviceLister := func() ([]*DeviceInfo, error) {
+	deviceLister := func(context.Context) ([]*DeviceInfo, error) {
 		return []*DeviceInfo{
 			&DeviceInfo{
 				Serial:  "abc",
@@ -53,7 +54,7 @@ func TestGetDeviceInfo(t *testing.T) {
 	assert.Nil(t, device)
 }
 
-func newDeviceClientWithDeviceLister(serial string, deviceLister func() ([]*DeviceInfo, error)) *Device {
+func newDeviceClientWithDeviceLister(serial string, deviceLister func(context.Context) ([]*DeviceInfo, error)) *Device {
 	client := (&Adb{&MockServer{
 		Status:   wire.StatusSuccess,
 		Messages: []string{serial},
@@ -62,6 +63,50 @@ func newDeviceClientWithDeviceLister(serial string, deviceLister func() ([]*Devi
 	return client
 }
 
+func TestDeviceWithTransportID(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"value", "output"},
+	}
+	client := (&Adb{s}).Device(DeviceWithTransportID(8))
+	assert.Equal(t, "DeviceTransportID[8]", client.String())
+
+	_, err := client.getAttribute(context.Background(), "attr")
+	assert.NoError(t, err)
+	_, err = client.RunCommand("cmd")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host-transport-id:8:attr", "host:transport-id:8", "shell:cmd"}, s.Requests)
+
+	id, err := client.TransportID()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), id)
+}
+
+func TestTransportID(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\x2a\x00\x00\x00\x00\x00\x00\x00"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	id, err := client.TransportID()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+	assert.Equal(t, []string{"host:tport:serial:abc"}, s.Requests)
+}
+
+func TestTransportIDIncomplete(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\x2a\x00"},
+	}
+	client := (&Adb{s}).Device(AnyUsbDevice())
+
+	_, err := client.TransportID()
+	assert.True(t, HasErrCode(err, NetworkError))
+	assert.Equal(t, []string{"host:tport:usb"}, s.Requests)
+}
+
 func TestRunCommandNoArgs(t *testing.T) {
 	s := &MockServer{
 		Status:   wire.StatusSuccess,
@@ -76,6 +121,25 @@ func TestRunCommandNoArgs(t *testing.T) {
 	assert.Equal(t, "output", v)
 }
 
+func TestRunCommandV2WithoutShellV2(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"cmd,stat_v2", "output\nls: x: No such file or directory\n", "\n1"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	exitCode, stdout, stderr, err := client.RunCommandV2("ls", "x")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, exitCode)
+	assert.Equal(t, "output\nls: x: No such file or directory\n", stdout)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, []string{
+		"host-serial:abc:features",
+		"host:transport:abc",
+		`exec:{ ls x; } 2>&1; printf '\n%d' $?`,
+	}, s.Requests)
+}
+
 func TestPrepareCommandLineNoArgs(t *testing.T) {
 	result, err := prepareCommandLine("cmd")
 	assert.NoError(t, err)
@@ -1,6 +1,8 @@
 package adb
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"math/rand"
 	"runtime"
@@ -27,35 +29,142 @@ type DeviceStateChangedEvent struct {
 	Serial   string
 	OldState DeviceState
 	NewState DeviceState
+
+	// Only set by watchers created with DeviceWatcherConfig.Long.
+	// OldInfo is nil if the device was just connected, and NewInfo if it was just disconnected.
+	OldInfo *DeviceInfo
+	NewInfo *DeviceInfo
+}
+
+// Entered returns true if this event represents a device changing to state.
+func (s DeviceStateChangedEvent) Entered(state DeviceState) bool {
+	return s.OldState != state && s.NewState == state
+}
+
+// Left returns true if this event represents a device changing from state.
+func (s DeviceStateChangedEvent) Left(state DeviceState) bool {
+	return s.OldState == state && s.NewState != state
 }
 
 // CameOnline returns true if this event represents a device coming online.
 func (s DeviceStateChangedEvent) CameOnline() bool {
-	return s.OldState != StateOnline && s.NewState == StateOnline
+	return s.Entered(StateOnline)
 }
 
 // WentOffline returns true if this event represents a device going offline.
 func (s DeviceStateChangedEvent) WentOffline() bool {
-	return s.OldState == StateOnline && s.NewState != StateOnline
+	return s.Left(StateOnline)
+}
+
+// Connected returns true if this event represents a device being connected, in any state.
+func (s DeviceStateChangedEvent) Connected() bool {
+	return s.Left(StateDisconnected)
+}
+
+// Disconnected returns true if this event represents a device being disconnected.
+func (s DeviceStateChangedEvent) Disconnected() bool {
+	return s.Entered(StateDisconnected)
+}
+
+// EnteredRecovery returns true if this event represents a device booting into recovery.
+func (s DeviceStateChangedEvent) EnteredRecovery() bool {
+	return s.Entered(StateRecovery)
+}
+
+// EnteredRescue returns true if this event represents a device booting into rescue mode.
+func (s DeviceStateChangedEvent) EnteredRescue() bool {
+	return s.Entered(StateRescue)
+}
+
+// EnteredSideload returns true if this event represents a device becoming ready for sideloading.
+func (s DeviceStateChangedEvent) EnteredSideload() bool {
+	return s.Entered(StateSideload)
+}
+
+// EnteredBootloader returns true if this event represents a device rebooting into the bootloader.
+func (s DeviceStateChangedEvent) EnteredBootloader() bool {
+	return s.Entered(StateBootloader)
+}
+
+// NeedsAuthorization returns true if this event represents a device waiting for the user to
+// authorize the host.
+func (s DeviceStateChangedEvent) NeedsAuthorization() bool {
+	return s.Entered(StateUnauthorized)
+}
+
+// LostPermissions returns true if this event represents the host being unable to open a device,
+// e.g. because of missing udev rules.
+func (s DeviceStateChangedEvent) LostPermissions() bool {
+	return s.Entered(StateNoPermissions)
+}
+
+// AttributesChanged returns true if this event represents a change to the device's
+// DeviceInfo (e.g. its transport or USB path) rather than, or as well as, its state.
+// Always false unless the watcher was created with DeviceWatcherConfig.Long.
+func (s DeviceStateChangedEvent) AttributesChanged() bool {
+	return s.OldInfo != nil && s.NewInfo != nil && *s.OldInfo != *s.NewInfo
+}
+
+// DeviceWatcherConfig configures a DeviceWatcher. The zero value is valid.
+type DeviceWatcherConfig struct {
+	// Backoff is called when the connection to the server is reset, with the number of consecutive
+	// resets so far (starting at 1). It returns how long to wait before restarting the server and
+	// reconnecting, or false to give up and close the channel returned by C.
+	// If nil, DefaultDeviceWatcherBackoff is used.
+	Backoff func(attempt int) (delay time.Duration, retry bool)
+
+	// Logf is used to report reconnects. If nil, log.Printf is used.
+	Logf func(format string, args ...interface{})
+
+	// If true, the watcher uses host:track-devices-l, which reports each device's DeviceInfo as
+	// well as its state. Events then have OldInfo and NewInfo set, and are also published when
+	// only a device's attributes change.
+	Long bool
+}
+
+// DefaultDeviceWatcherBackoff always retries, after a random delay in [0ms, 500ms) in case
+// multiple DeviceWatchers are trying to start the same server.
+func DefaultDeviceWatcherBackoff(attempt int) (time.Duration, bool) {
+	return time.Duration(rand.Intn(500)) * time.Millisecond, true
 }
 
 type deviceWatcherImpl struct {
 	server server
+	config DeviceWatcherConfig
 
 	// If an error occurs, it is stored here and eventChan is close immediately after.
 	err atomic.Value
 
 	eventChan chan DeviceStateChangedEvent
+
+	// Cancelled by Shutdown to stop publishDevices, which closes done when it returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-func newDeviceWatcher(server server) *DeviceWatcher {
+func newDeviceWatcher(server server, config DeviceWatcherConfig) *DeviceWatcher {
+	if config.Backoff == nil {
+		config.Backoff = DefaultDeviceWatcherBackoff
+	}
+	if config.Logf == nil {
+		config.Logf = log.Printf
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	watcher := &DeviceWatcher{&deviceWatcherImpl{
 		server:    server,
+		config:    config,
 		eventChan: make(chan DeviceStateChangedEvent),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
 	}}
 
 	runtime.SetFinalizer(watcher, func(watcher *DeviceWatcher) {
-		watcher.Shutdown()
+		// Unlike Shutdown, don't wait for publishDevices to return, which would block all the
+		// other finalizers.
+		watcher.cancel()
 	})
 
 	go publishDevices(watcher.deviceWatcherImpl)
@@ -73,6 +182,7 @@ func (w *DeviceWatcher) C() <-chan DeviceStateChangedEvent {
 
 // Err returns the error that caused the channel returned by C to be closed, if C is closed.
 // If C is not closed, its return value is undefined.
+// Returns nil if the channel was closed by Shutdown.
 func (w *DeviceWatcher) Err() error {
 	if err, ok := w.err.Load().(error); ok {
 		return err
@@ -80,61 +190,85 @@ func (w *DeviceWatcher) Err() error {
 	return nil
 }
 
-// Shutdown stops the watcher from listening for events and closes the channel returned
-// from C.
+// Shutdown stops the watcher from listening for events, closes the connection to the server,
+// and closes the channel returned from C. It blocks until the watcher's goroutine has exited.
+// It is safe to call Shutdown more than once.
 func (w *DeviceWatcher) Shutdown() {
-	// TODO(z): Implement.
+	w.cancel()
+	<-w.done
 }
 
 func (w *deviceWatcherImpl) reportErr(err error) {
 	w.err.Store(err)
 }
 
+// trackDevicesMessage is either a message read from a track-devices connection, or the error
+// that ended it.
+type trackDevicesMessage struct {
+	msg []byte
+	err error
+}
+
 /*
-publishDevices reads device lists from scanner, calculates diffs, and publishes events on
-eventChan.
-Returns when scanner returns an error.
+publishDevices reads device lists from the server, calculates diffs, and publishes events on
+eventChan until watcher.ctx is cancelled or an unrecoverable error occurs.
 Doesn't refer directly to a *DeviceWatcher so it can be GCed (which will,
-in turn, close Scanner and stop this goroutine).
-
-TODO: to support shutdown, spawn a new goroutine each time a server connection is established.
-This goroutine should read messages and send them to a message channel. Can write errors directly
-to errVal. publishDevicesUntilError should take the msg chan and the scanner and select on the msg chan and stop chan, and if the stop
-chan sends, close the scanner and return true. If the msg chan closes, just return false.
-publishDevices can look at ret val: if false and err == EOF, reconnect. If false and other error, report err
-and abort. If true, report no error and stop.
+in turn, cancel watcher.ctx and stop this goroutine).
+
+A new goroutine reads messages from each server connection (see readTrackDevicesMessages), so
+publishDevicesUntilError can stop waiting for the next message as soon as Shutdown is called.
+If the connection is reset, the server is restarted and a new connection is made after waiting
+as long as config.Backoff says.
 */
 func publishDevices(watcher *deviceWatcherImpl) {
+	defer close(watcher.done)
 	defer close(watcher.eventChan)
 
-	var lastKnownStates map[string]DeviceState
-	finished := false
+	lastKnownDevices := trackedDevices{long: watcher.config.Long}
+	attempt := 0
 
 	for {
-		scanner, err := connectToTrackDevices(watcher.server)
+		scanner, err := connectToTrackDevices(watcher.ctx, watcher.server, watcher.config.Long)
 		if err != nil {
-			watcher.reportErr(err)
+			if watcher.ctx.Err() == nil {
+				watcher.reportErr(err)
+			}
 			return
 		}
 
-		finished, err = publishDevicesUntilError(scanner, watcher.eventChan, &lastKnownStates)
+		msgChan := make(chan trackDevicesMessage)
+		go readTrackDevicesMessages(watcher.ctx, scanner, msgChan)
+
+		finished, receivedMessages, err := publishDevicesUntilError(watcher.ctx, msgChan, watcher.eventChan, &lastKnownDevices)
+		scanner.Close()
 
 		if finished {
-			scanner.Close()
 			return
 		}
 
+		if receivedMessages {
+			attempt = 0
+		}
+
 		if HasErrCode(err, ConnectionResetError) {
 			// The server died, restart and reconnect.
+			attempt++
+			delay, retry := watcher.config.Backoff(attempt)
+			if !retry {
+				watcher.config.Logf("[DeviceWatcher] server died, giving up after %d attempts", attempt)
+				watcher.reportErr(err)
+				return
+			}
 
-			// Delay by a random [0ms, 500ms) in case multiple DeviceWatchers are trying to
-			// start the same server.
-			delay := time.Duration(rand.Intn(500)) * time.Millisecond
+			watcher.config.Logf("[DeviceWatcher] server died, restarting in %s…", delay)
+			select {
+			case <-time.After(delay):
+			case <-watcher.ctx.Done():
+				return
+			}
 
-			log.Printf("[DeviceWatcher] server died, restarting in %s…", delay)
-			time.Sleep(delay)
 			if err := watcher.server.Start(); err != nil {
-				log.Println("[DeviceWatcher] error restarting server, giving up")
+				watcher.config.Logf("[DeviceWatcher] error restarting server, giving up")
 				watcher.reportErr(err)
 				return
 			} // Else server should be running, continue listening.
@@ -146,18 +280,22 @@ func publishDevices(watcher *deviceWatcherImpl) {
 	}
 }
 
-func connectToTrackDevices(server server) (wire.Scanner, error) {
-	conn, err := server.Dial()
+func connectToTrackDevices(ctx context.Context, server server, long bool) (wire.Scanner, error) {
+	conn, err := server.Dial(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := wire.SendMessageString(conn, "host:track-devices"); err != nil {
+	req := "host:track-devices"
+	if long {
+		req = "host:track-devices-l"
+	}
+	if err := wire.SendMessageString(conn, req); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
-	if _, err := conn.ReadStatus("host:track-devices"); err != nil {
+	if _, err := conn.ReadStatus(req); err != nil {
 		conn.Close()
 		return nil, err
 	}
@@ -165,25 +303,91 @@ func connectToTrackDevices(server server) (wire.Scanner, error) {
 	return conn, nil
 }
 
-func publishDevicesUntilError(scanner wire.Scanner, eventChan chan<- DeviceStateChangedEvent, lastKnownStates *map[string]DeviceState) (finished bool, err error) {
+// readTrackDevicesMessages sends messages read from scanner to msgChan until reading fails,
+// then sends the error and closes msgChan.
+// Returns early if ctx is done, which publishDevices handles by closing scanner.
+func readTrackDevicesMessages(ctx context.Context, scanner wire.Scanner, msgChan chan<- trackDevicesMessage) {
+	defer close(msgChan)
+
 	for {
 		msg, err := scanner.ReadMessage()
+		select {
+		case msgChan <- trackDevicesMessage{msg, err}:
+		case <-ctx.Done():
+			return
+		}
 		if err != nil {
-			return false, err
+			return
 		}
+	}
+}
+
+// publishDevicesUntilError publishes events for messages from msgChan until it receives an error
+// or ctx is done, in which case finished is true.
+// receivedMessages is true if at least one message was successfully read.
+func publishDevicesUntilError(ctx context.Context, msgChan <-chan trackDevicesMessage, eventChan chan<- DeviceStateChangedEvent, lastKnownDevices *trackedDevices) (finished, receivedMessages bool, err error) {
+	for {
+		var msg trackDevicesMessage
+		var ok bool
+		select {
+		case msg, ok = <-msgChan:
+		case <-ctx.Done():
+			return true, receivedMessages, nil
+		}
+		if !ok {
+			// The reader only stops without sending an error when ctx is done.
+			return true, receivedMessages, nil
+		}
+		if msg.err != nil {
+			return false, receivedMessages, msg.err
+		}
+		receivedMessages = true
 
-		deviceStates, err := parseDeviceStates(string(msg))
+		var devices trackedDevices
+		if lastKnownDevices.long {
+			devices, err = parseDeviceInfos(string(msg.msg))
+		} else {
+			devices.states, err = parseDeviceStates(string(msg.msg))
+		}
 		if err != nil {
-			return false, err
+			return false, receivedMessages, err
 		}
 
-		for _, event := range calculateStateDiffs(*lastKnownStates, deviceStates) {
-			eventChan <- event
+		events := calculateStateDiffs(lastKnownDevices.states, devices.states)
+		if lastKnownDevices.long {
+			events = calculateDeviceInfoDiffs(events, lastKnownDevices.infos, devices.infos, devices.states)
+		}
+		for _, event := range events {
+			select {
+			case eventChan <- event:
+			case <-ctx.Done():
+				return true, receivedMessages, nil
+			}
 		}
-		*lastKnownStates = deviceStates
+		*lastKnownDevices = devices
 	}
 }
 
+// trackedDevices is a device list received from the server.
+type trackedDevices struct {
+	// True if the list came from host:track-devices-l.
+	long bool
+
+	// Keyed by serial, or by deviceKey if long is true.
+	states map[string]DeviceState
+	// Only set if long is true.
+	infos map[string]*DeviceInfo
+}
+
+// deviceKey identifies info's device in a long device list. Devices are keyed by transport ID when
+// the server reports it, since several devices can have the same serial (e.g. "????????").
+func deviceKey(info *DeviceInfo) string {
+	if info.TransportID != 0 {
+		return fmt.Sprintf("transport_id:%d", info.TransportID)
+	}
+	return info.Serial
+}
+
 func parseDeviceStates(msg string) (states map[string]DeviceState, err error) {
 	states = make(map[string]DeviceState)
 
@@ -207,6 +411,43 @@ func parseDeviceStates(msg string) (states map[string]DeviceState, err error) {
 	return
 }
 
+// parseDeviceInfos parses a message from host:track-devices-l, which has the same format as
+// the output of host:devices-l.
+func parseDeviceInfos(msg string) (devices trackedDevices, err error) {
+	devices = trackedDevices{
+		long:   true,
+		states: make(map[string]DeviceState),
+		infos:  make(map[string]*DeviceInfo),
+	}
+
+	for lineNum, line := range strings.Split(msg, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		serial, stateString, attrs, splitErr := splitDeviceLong(line)
+		if splitErr != nil {
+			err = errors.WrapErrorf(splitErr, errors.ParseError, "invalid device line %d: %s", lineNum, line)
+			return
+		}
+
+		var state DeviceState
+		state, err = parseDeviceState(stateString)
+		if err != nil {
+			return
+		}
+		var info *DeviceInfo
+		info, err = newDevice(serial, parseDeviceAttributes(attrs))
+		if err != nil {
+			return
+		}
+
+		devices.states[deviceKey(info)] = state
+		devices.infos[deviceKey(info)] = info
+	}
+
+	return
+}
+
 func calculateStateDiffs(oldStates, newStates map[string]DeviceState) (events []DeviceStateChangedEvent) {
 	for serial, oldState := range oldStates {
 		newState, ok := newStates[serial]
@@ -214,10 +455,10 @@ func calculateStateDiffs(oldStates, newStates map[string]DeviceState) (events []
 		if oldState != newState {
 			if ok {
 				// Device present in both lists: state changed.
-				events = append(events, DeviceStateChangedEvent{serial, oldState, newState})
+				events = append(events, DeviceStateChangedEvent{Serial: serial, OldState: oldState, NewState: newState})
 			} else {
 				// Device only present in old list: device removed.
-				events = append(events, DeviceStateChangedEvent{serial, oldState, StateDisconnected})
+				events = append(events, DeviceStateChangedEvent{Serial: serial, OldState: oldState, NewState: StateDisconnected})
 			}
 		}
 	}
@@ -225,8 +466,43 @@ func calculateStateDiffs(oldStates, newStates map[string]DeviceState) (events []
 	for serial, newState := range newStates {
 		if _, ok := oldStates[serial]; !ok {
 			// Device only present in new list: device added.
-			events = append(events, DeviceStateChangedEvent{serial, StateDisconnected, newState})
+			events = append(events, DeviceStateChangedEvent{Serial: serial, OldState: StateDisconnected, NewState: newState})
+		}
+	}
+
+	return events
+}
+
+// calculateDeviceInfoDiffs sets OldInfo and NewInfo on the events returned by calculateStateDiffs,
+// and adds events for devices whose state didn't change but whose attributes did.
+// The maps are keyed by deviceKey, as are the Serials of the events passed in; the Serials of the
+// events returned are the devices' actual serials.
+func calculateDeviceInfoDiffs(events []DeviceStateChangedEvent, oldInfos, newInfos map[string]*DeviceInfo, newStates map[string]DeviceState) []DeviceStateChangedEvent {
+	changedKeys := make(map[string]bool)
+	for i := range events {
+		key := events[i].Serial
+		changedKeys[key] = true
+		events[i].OldInfo = oldInfos[key]
+		events[i].NewInfo = newInfos[key]
+		if events[i].NewInfo != nil {
+			events[i].Serial = events[i].NewInfo.Serial
+		} else if events[i].OldInfo != nil {
+			events[i].Serial = events[i].OldInfo.Serial
+		}
+	}
+
+	for key, newInfo := range newInfos {
+		oldInfo, ok := oldInfos[key]
+		if !ok || *oldInfo == *newInfo || changedKeys[key] {
+			continue
 		}
+		events = append(events, DeviceStateChangedEvent{
+			Serial:   newInfo.Serial,
+			OldState: newStates[key],
+			NewState: newStates[key],
+			OldInfo:  oldInfo,
+			NewInfo:  newInfo,
+		})
 	}
 
 	return events
@@ -1,7 +1,9 @@
 package adb
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/drtechco/goadb/internal/errors"
 	"github.com/drtechco/goadb/wire"
@@ -37,6 +39,39 @@ func TestParseDeviceStatesMalformed(t *testing.T) {
 	assert.Equal(t, "invalid device state line 1: 0x0x0x0x", err.(*errors.Err).Message)
 }
 
+func TestParseDeviceStatesNoPermissions(t *testing.T) {
+	states, err := parseDeviceStates("0123456789ABCDEF\tno permissions (missing udev rules? user is in the plugdev group); " +
+		"see [http://developer.android.com/tools/device.html]\nemulator-5554\tbootloader\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DeviceState{
+		"0123456789ABCDEF": StateNoPermissions,
+		"emulator-5554":    StateBootloader,
+	}, states)
+}
+
+func TestDeviceStateChangedEventTransitions(t *testing.T) {
+	event := DeviceStateChangedEvent{OldState: StateOnline, NewState: StateRecovery}
+	assert.True(t, event.WentOffline())
+	assert.True(t, event.EnteredRecovery())
+	assert.True(t, event.Left(StateOnline))
+	assert.False(t, event.CameOnline())
+	assert.False(t, event.EnteredSideload())
+
+	event = DeviceStateChangedEvent{OldState: StateDisconnected, NewState: StateNoPermissions}
+	assert.True(t, event.Connected())
+	assert.True(t, event.LostPermissions())
+	assert.False(t, event.Disconnected())
+
+	event = DeviceStateChangedEvent{OldState: StateAuthorizing, NewState: StateUnauthorized}
+	assert.True(t, event.NeedsAuthorization())
+	assert.True(t, event.Left(StateAuthorizing))
+
+	event = DeviceStateChangedEvent{OldState: StateBootloader, NewState: StateDisconnected}
+	assert.True(t, event.Disconnected())
+	assert.False(t, event.EnteredBootloader())
+}
+
 func TestCalculateStateDiffsUnchangedEmpty(t *testing.T) {
 	oldStates := map[string]DeviceState{}
 	newStates := map[string]DeviceState{}
@@ -70,7 +105,7 @@ func TestCalculateStateDiffsOneAdded(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"serial", StateDisconnected, StateOffline},
+		DeviceStateChangedEvent{Serial: "serial", OldState: StateDisconnected, NewState: StateOffline},
 	}, diffs)
 }
 
@@ -83,7 +118,7 @@ func TestCalculateStateDiffsOneRemoved(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"serial", StateOffline, StateDisconnected},
+		DeviceStateChangedEvent{Serial: "serial", OldState: StateOffline, NewState: StateDisconnected},
 	}, diffs)
 }
 
@@ -99,7 +134,7 @@ func TestCalculateStateDiffsOneAddedOneUnchanged(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"2", StateDisconnected, StateOffline},
+		DeviceStateChangedEvent{Serial: "2", OldState: StateDisconnected, NewState: StateOffline},
 	}, diffs)
 }
 
@@ -115,7 +150,7 @@ func TestCalculateStateDiffsOneRemovedOneUnchanged(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"1", StateOffline, StateDisconnected},
+		DeviceStateChangedEvent{Serial: "1", OldState: StateOffline, NewState: StateDisconnected},
 	}, diffs)
 }
 
@@ -130,8 +165,8 @@ func TestCalculateStateDiffsOneAddedOneRemoved(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"1", StateOffline, StateDisconnected},
-		DeviceStateChangedEvent{"2", StateDisconnected, StateOffline},
+		DeviceStateChangedEvent{Serial: "1", OldState: StateOffline, NewState: StateDisconnected},
+		DeviceStateChangedEvent{Serial: "2", OldState: StateDisconnected, NewState: StateOffline},
 	}, diffs)
 }
 
@@ -148,7 +183,7 @@ func TestCalculateStateDiffsOneChangedOneUnchanged(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"1", StateOffline, StateOnline},
+		DeviceStateChangedEvent{Serial: "1", OldState: StateOffline, NewState: StateOnline},
 	}, diffs)
 }
 
@@ -165,8 +200,8 @@ func TestCalculateStateDiffsMultipleChanged(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"1", StateOffline, StateOnline},
-		DeviceStateChangedEvent{"2", StateOnline, StateOffline},
+		DeviceStateChangedEvent{Serial: "1", OldState: StateOffline, NewState: StateOnline},
+		DeviceStateChangedEvent{Serial: "2", OldState: StateOnline, NewState: StateOffline},
 	}, diffs)
 }
 
@@ -183,26 +218,26 @@ func TestCalculateStateDiffsOneAddedOneRemovedOneChanged(t *testing.T) {
 	diffs := calculateStateDiffs(oldStates, newStates)
 
 	assertContainsOnly(t, []DeviceStateChangedEvent{
-		DeviceStateChangedEvent{"1", StateOffline, StateOnline},
-		DeviceStateChangedEvent{"2", StateOffline, StateDisconnected},
-		DeviceStateChangedEvent{"3", StateDisconnected, StateOffline},
+		DeviceStateChangedEvent{Serial: "1", OldState: StateOffline, NewState: StateOnline},
+		DeviceStateChangedEvent{Serial: "2", OldState: StateOffline, NewState: StateDisconnected},
+		DeviceStateChangedEvent{Serial: "3", OldState: StateDisconnected, NewState: StateOffline},
 	}, diffs)
 }
 
 func TestCameOnline(t *testing.T) {
-	assert.True(t, DeviceStateChangedEvent{"", StateDisconnected, StateOnline}.CameOnline())
-	assert.True(t, DeviceStateChangedEvent{"", StateOffline, StateOnline}.CameOnline())
-	assert.False(t, DeviceStateChangedEvent{"", StateOnline, StateOffline}.CameOnline())
-	assert.False(t, DeviceStateChangedEvent{"", StateOnline, StateDisconnected}.CameOnline())
-	assert.False(t, DeviceStateChangedEvent{"", StateOffline, StateDisconnected}.CameOnline())
+	assert.True(t, DeviceStateChangedEvent{Serial: "", OldState: StateDisconnected, NewState: StateOnline}.CameOnline())
+	assert.True(t, DeviceStateChangedEvent{Serial: "", OldState: StateOffline, NewState: StateOnline}.CameOnline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateOnline, NewState: StateOffline}.CameOnline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateOnline, NewState: StateDisconnected}.CameOnline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateOffline, NewState: StateDisconnected}.CameOnline())
 }
 
 func TestWentOffline(t *testing.T) {
-	assert.True(t, DeviceStateChangedEvent{"", StateOnline, StateDisconnected}.WentOffline())
-	assert.True(t, DeviceStateChangedEvent{"", StateOnline, StateOffline}.WentOffline())
-	assert.False(t, DeviceStateChangedEvent{"", StateOffline, StateOnline}.WentOffline())
-	assert.False(t, DeviceStateChangedEvent{"", StateDisconnected, StateOnline}.WentOffline())
-	assert.False(t, DeviceStateChangedEvent{"", StateOffline, StateDisconnected}.WentOffline())
+	assert.True(t, DeviceStateChangedEvent{Serial: "", OldState: StateOnline, NewState: StateDisconnected}.WentOffline())
+	assert.True(t, DeviceStateChangedEvent{Serial: "", OldState: StateOnline, NewState: StateOffline}.WentOffline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateOffline, NewState: StateOnline}.WentOffline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateDisconnected, NewState: StateOnline}.WentOffline())
+	assert.False(t, DeviceStateChangedEvent{Serial: "", OldState: StateOffline, NewState: StateDisconnected}.WentOffline())
 }
 
 func TestPublishDevicesRestartsServer(t *testing.T) {
@@ -211,23 +246,241 @@ func TestPublishDevicesRestartsServer(t *testing.T) {
 		Errs: []error{
 			nil, nil, nil, // Successful dial.
 			errors.Errorf(errors.ConnectionResetError, "failed first read"),
+			nil, nil, // Close sender and scanner.
 			errors.Errorf(errors.ServerNotAvailable, "failed redial"),
 		},
 	}
-	watcher := deviceWatcherImpl{
-		server:    server,
-		eventChan: make(chan DeviceStateChangedEvent),
-	}
+	var backoffAttempts []int
+	watcher := newTestDeviceWatcherImpl(server, DeviceWatcherConfig{
+		Backoff: func(attempt int) (time.Duration, bool) {
+			backoffAttempts = append(backoffAttempts, attempt)
+			return 0, true
+		},
+	})
 
-	publishDevices(&watcher)
+	publishDevices(watcher)
 
 	assert.Empty(t, server.Errs)
 	assert.Equal(t, []string{"host:track-devices"}, server.Requests)
-	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "ReadMessage", "Start", "Dial"}, server.Trace)
+	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "ReadMessage", "Close", "Close", "Start", "Dial"}, server.Trace)
+	assert.Equal(t, []int{1}, backoffAttempts)
 	err := watcher.err.Load().(*errors.Err)
 	assert.Equal(t, errors.ServerNotAvailable, err.Code)
 }
 
+func TestPublishDevicesGivesUpWhenBackoffSaysSo(t *testing.T) {
+	server := &MockServer{
+		Status: wire.StatusSuccess,
+		Errs: []error{
+			nil, nil, nil, // Successful dial.
+			errors.Errorf(errors.ConnectionResetError, "failed first read"),
+		},
+	}
+	watcher := newTestDeviceWatcherImpl(server, DeviceWatcherConfig{
+		Backoff: func(attempt int) (time.Duration, bool) {
+			return 0, false
+		},
+	})
+
+	publishDevices(watcher)
+
+	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "ReadMessage", "Close", "Close"}, server.Trace)
+	err := watcher.err.Load().(*errors.Err)
+	assert.Equal(t, errors.ConnectionResetError, err.Code)
+}
+
+func TestDeviceWatcherShutdown(t *testing.T) {
+	msgChan := make(chan trackDevicesMessage)
+	eventChan := make(chan DeviceStateChangedEvent)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	finishedChan := make(chan bool)
+	go func() {
+		var lastKnownDevices trackedDevices
+		finished, _, _ := publishDevicesUntilError(ctx, msgChan, eventChan, &lastKnownDevices)
+		finishedChan <- finished
+	}()
+
+	// Block trying to publish an event nobody is receiving.
+	msgChan <- trackDevicesMessage{msg: []byte("serial\tdevice\n")}
+	cancel()
+	assert.True(t, <-finishedChan)
+}
+
+func TestDeviceWatcherShutdownClosesChannel(t *testing.T) {
+	server := &MockServer{
+		Status: wire.StatusSuccess,
+		Errs: []error{
+			errors.Errorf(errors.ServerNotAvailable, "failed dial"),
+		},
+	}
+	watcher := (&Adb{server}).NewDeviceWatcher()
+	watcher.Shutdown()
+
+	_, ok := <-watcher.C()
+	assert.False(t, ok)
+	// Shutting down twice is safe.
+	watcher.Shutdown()
+}
+
+func newTestDeviceWatcherImpl(server server, config DeviceWatcherConfig) *deviceWatcherImpl {
+	ctx, cancel := context.WithCancel(context.Background())
+	config.Logf = func(string, ...interface{}) {}
+	return &deviceWatcherImpl{
+		server:    server,
+		config:    config,
+		eventChan: make(chan DeviceStateChangedEvent),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+func TestParseDeviceInfos(t *testing.T) {
+	devices, err := parseDeviceInfos(`SERIAL1                device usb:1-1 product:PRODUCT model:MODEL device:DEVICE transport_id:1
+SERIAL2                offline transport_id:2
+`)
+
+	assert.NoError(t, err)
+	assert.True(t, devices.long)
+	assert.Equal(t, map[string]DeviceState{
+		"transport_id:1": StateOnline,
+		"transport_id:2": StateOffline,
+	}, devices.states)
+	assert.Equal(t, &DeviceInfo{
+		Serial:      "SERIAL1",
+		Product:     "PRODUCT",
+		Model:       "MODEL",
+		DeviceInfo:  "DEVICE",
+		Usb:         "1-1",
+		TransportID: 1,
+	}, devices.infos["transport_id:1"])
+	assert.Equal(t, &DeviceInfo{Serial: "SERIAL2", TransportID: 2}, devices.infos["transport_id:2"])
+}
+
+func TestParseDeviceInfosDuplicateSerials(t *testing.T) {
+	devices, err := parseDeviceInfos(`????????               no permissions usb:1-1 transport_id:1
+????????               no permissions usb:1-2 transport_id:2
+SERIAL                 device
+`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DeviceState{
+		"transport_id:1": StateNoPermissions,
+		"transport_id:2": StateNoPermissions,
+		"SERIAL":         StateOnline,
+	}, devices.states)
+	assert.Equal(t, &DeviceInfo{Serial: "????????", Usb: "1-2", TransportID: 2}, devices.infos["transport_id:2"])
+	assert.Equal(t, &DeviceInfo{Serial: "SERIAL"}, devices.infos["SERIAL"])
+}
+
+func TestParseDeviceInfosAllStates(t *testing.T) {
+	devices, err := parseDeviceInfos(`SERIAL1                recovery product:PRODUCT transport_id:1
+SERIAL2                no permissions (user in plugdev group; are your udev rules wrong?); see [http://developer.android.com/tools/device.html] usb:1-2 transport_id:2
+SERIAL3                sideload transport_id:3
+`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DeviceState{
+		"transport_id:1": StateRecovery,
+		"transport_id:2": StateNoPermissions,
+		"transport_id:3": StateSideload,
+	}, devices.states)
+	assert.Equal(t, &DeviceInfo{Serial: "SERIAL2", Usb: "1-2", TransportID: 2}, devices.infos["transport_id:2"])
+}
+
+func TestParseDeviceInfosMalformed(t *testing.T) {
+	_, err := parseDeviceInfos("SERIAL1 device\nSERIAL2\n")
+	assert.True(t, HasErrCode(err, ParseError))
+	assert.Equal(t, "invalid device line 1: SERIAL2", err.(*errors.Err).Message)
+}
+
+func TestCalculateDeviceInfoDiffs(t *testing.T) {
+	oldInfos := map[string]*DeviceInfo{
+		"1": {Serial: "1", Usb: "1-1"},
+		"2": {Serial: "2", Model: "MODEL"},
+		"3": {Serial: "3"},
+	}
+	newInfos := map[string]*DeviceInfo{
+		"1": {Serial: "1", Usb: "1-2"},
+		"2": {Serial: "2", Model: "MODEL"},
+		"4": {Serial: "4"},
+	}
+	newStates := map[string]DeviceState{
+		"1": StateOnline,
+		"2": StateOnline,
+		"4": StateOffline,
+	}
+	stateEvents := []DeviceStateChangedEvent{
+		{Serial: "3", OldState: StateOnline, NewState: StateDisconnected},
+		{Serial: "4", OldState: StateDisconnected, NewState: StateOffline},
+	}
+
+	diffs := calculateDeviceInfoDiffs(stateEvents, oldInfos, newInfos, newStates)
+
+	assertContainsOnly(t, []DeviceStateChangedEvent{
+		{Serial: "1", OldState: StateOnline, NewState: StateOnline, OldInfo: oldInfos["1"], NewInfo: newInfos["1"]},
+		{Serial: "3", OldState: StateOnline, NewState: StateDisconnected, OldInfo: oldInfos["3"]},
+		{Serial: "4", OldState: StateDisconnected, NewState: StateOffline, NewInfo: newInfos["4"]},
+	}, diffs)
+	for _, diff := range diffs {
+		assert.Equal(t, diff.Serial == "1", diff.AttributesChanged())
+	}
+}
+
+func TestPublishDevicesLong(t *testing.T) {
+	server := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"SERIAL device usb:1-1 transport_id:1\n", "SERIAL device usb:1-2 transport_id:1\n"},
+	}
+	watcher := newTestDeviceWatcherImpl(server, DeviceWatcherConfig{Long: true})
+	go publishDevices(watcher)
+
+	event := <-watcher.eventChan
+	assert.True(t, event.CameOnline())
+	assert.Equal(t, &DeviceInfo{Serial: "SERIAL", Usb: "1-1", TransportID: 1}, event.NewInfo)
+
+	event = <-watcher.eventChan
+	assert.True(t, event.AttributesChanged())
+	assert.Equal(t, "1-2", event.NewInfo.Usb)
+
+	// The mock server returns EOF after the last message.
+	<-watcher.done
+	assert.Equal(t, "host:track-devices-l", server.Requests[0])
+	assert.True(t, HasErrCode(watcher.err.Load().(error), NetworkError))
+}
+
+func TestPublishDevicesLongDuplicateSerials(t *testing.T) {
+	server := &MockServer{
+		Status: wire.StatusSuccess,
+		Messages: []string{
+			"???????? unauthorized usb:1-1 transport_id:1\n",
+			"???????? unauthorized usb:1-1 transport_id:1\n???????? unauthorized usb:1-2 transport_id:2\n",
+			"???????? unauthorized usb:1-2 transport_id:2\n",
+		},
+	}
+	watcher := newTestDeviceWatcherImpl(server, DeviceWatcherConfig{Long: true})
+	go publishDevices(watcher)
+
+	event := <-watcher.eventChan
+	assert.True(t, event.NeedsAuthorization())
+	assert.Equal(t, "????????", event.Serial)
+	assert.Equal(t, "1-1", event.NewInfo.Usb)
+
+	event = <-watcher.eventChan
+	assert.True(t, event.Connected())
+	assert.Equal(t, "????????", event.Serial)
+	assert.Equal(t, "1-2", event.NewInfo.Usb)
+
+	event = <-watcher.eventChan
+	assert.True(t, event.Disconnected())
+	assert.Equal(t, "????????", event.Serial)
+	assert.Equal(t, "1-1", event.OldInfo.Usb)
+
+	<-watcher.done
+	assert.True(t, HasErrCode(watcher.err.Load().(error), NetworkError))
+}
+
 func assertContainsOnly(t *testing.T, expected, actual []DeviceStateChangedEvent) {
 	assert.Len(t, actual, len(expected))
 	for _, expectedEntry := range expected {
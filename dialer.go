@@ -1,8 +1,11 @@
 package adb
 
 import (
+	"context"
+	stderrors "errors"
 	"io"
 	"net"
+	"os"
 	"runtime"
 
 	"github.com/zach-klippenstein/goadb/internal/errors"
@@ -12,21 +15,39 @@ import (
 // Dialer knows how to create connections to an adb server.
 type Dialer interface {
 	Dial(address string) (*wire.Conn, error)
+
+	// DialContext is like Dial, but the returned connection must be closed when ctx is done,
+	// so that any operation blocked on it returns.
+	DialContext(ctx context.Context, address string) (*wire.Conn, error)
 }
 
 type tcpDialer struct{}
 
 // Dial connects to the adb server on the host and port set on the netDialer.
 // The zero-value will connect to the default, localhost:5037.
-func (tcpDialer) Dial(address string) (*wire.Conn, error) {
-	netConn, err := net.Dial("tcp", address)
+func (d tcpDialer) Dial(address string) (*wire.Conn, error) {
+	return d.DialContext(context.Background(), address)
+}
+
+// DialContext is like Dial, but closes the connection when ctx is done.
+// If ctx has a deadline, it's also set as the deadline of the network connection.
+func (tcpDialer) DialContext(ctx context.Context, address string) (*wire.Conn, error) {
+	var netDialer net.Dialer
+	netConn, err := netDialer.DialContext(ctx, "tcp", address)
 	if err != nil {
 		return nil, errors.WrapErrorf(err, errors.ServerNotAvailable, "error dialing %s", address)
 	}
 
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := netConn.SetDeadline(deadline); err != nil {
+			netConn.Close()
+			return nil, errors.WrapErrorf(err, errors.NetworkError, "error setting deadline on %s", address)
+		}
+	}
+
 	// net.Conn can't be closed more than once, but wire.Conn will try to close both sender and scanner
 	// so we need to wrap it to make it safe.
-	safeConn := wire.MultiCloseable(netConn)
+	safeConn := wire.MultiCloseable(newContextConn(ctx, netConn))
 
 	// Prevent leaking the network connection, not sure if TCPConn does this itself.
 	// Note that the network connection may still be in use after the conn isn't (scanners/senders
@@ -41,3 +62,61 @@ func (tcpDialer) Dial(address string) (*wire.Conn, error) {
 		Sender:  wire.NewSender(safeConn),
 	}, nil
 }
+
+// contextConn closes the wrapped connection when ctx is done, and reports ctx's error instead of
+// the network error caused by closing it, so callers can check for context.Canceled.
+type contextConn struct {
+	net.Conn
+	ctx context.Context
+
+	// Unregisters the close callback from ctx.
+	stop func() bool
+}
+
+func newContextConn(ctx context.Context, conn net.Conn) *contextConn {
+	c := &contextConn{
+		Conn: conn,
+		ctx:  ctx,
+	}
+	c.stop = context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	return c
+}
+
+func (c *contextConn) Read(buf []byte) (int, error) {
+	n, err := c.Conn.Read(buf)
+	return n, c.contextErr(err)
+}
+
+func (c *contextConn) Write(buf []byte) (int, error) {
+	n, err := c.Conn.Write(buf)
+	return n, c.contextErr(err)
+}
+
+func (c *contextConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the wrapped connection, if it supports it.
+func (c *contextConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return c.contextErr(closer.CloseWrite())
+	}
+	return errors.Errorf(errors.AssertionError, "connection doesn't support closing for writing")
+}
+
+func (c *contextConn) contextErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if stderrors.Is(err, os.ErrDeadlineExceeded) {
+		// The deadline set from ctx may fire slightly before ctx itself reports it.
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package adb
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextConnClosesOnCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := newContextConn(ctx, client)
+
+	go cancel()
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestContextConnPassesThroughErrors(t *testing.T) {
+	client, server := net.Pipe()
+	conn := newContextConn(context.Background(), client)
+
+	server.Close()
+	_, err := conn.Read(make([]byte, 1))
+	assert.EqualError(t, err, "EOF")
+	assert.NoError(t, conn.Close())
+}
+
+func TestTcpDialerDialContextCancelsReads(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept but never respond, like a wedged server.
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conn, err := tcpDialer{}.DialContext(ctx, listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.ReadStatus("req")
+	assert.True(t, HasErrCode(err, NetworkError))
+	assert.True(t, stderrors.Is(err, context.DeadlineExceeded))
+}
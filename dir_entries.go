@@ -5,6 +5,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/zach-klippenstein/goadb/internal/errors"
 	"github.com/zach-klippenstein/goadb/wire"
 )
 
@@ -12,13 +13,27 @@ import (
 type DirEntry struct {
 	Name       string
 	Mode       os.FileMode
-	Size       int32
+	Size       int64
 	ModifiedAt time.Time
+
+	// The following are only reported by devices that support the v2 sync protocol,
+	// and are zero otherwise.
+	UID        uint32
+	GID        uint32
+	Inode      uint64
+	Nlink      uint32
+	Device     uint64
+	AccessedAt time.Time
+	ChangedAt  time.Time
 }
 
 // DirEntries iterates over directory entries.
 type DirEntries struct {
 	scanner wire.SyncScanner
+	// True if the entries are DNT2 messages.
+	v2 bool
+	// True once the DONE message has been read.
+	done bool
 
 	currentEntry *DirEntry
 	err          error
@@ -43,7 +58,7 @@ func (entries *DirEntries) Next() bool {
 		return false
 	}
 
-	entry, done, err := readNextDirListEntry(entries.scanner)
+	entry, done, err := entries.readNext()
 	if err != nil {
 		entries.err = err
 		entries.Close()
@@ -52,6 +67,7 @@ func (entries *DirEntries) Next() bool {
 
 	entries.currentEntry = entry
 	if done {
+		entries.done = true
 		entries.Close()
 		return false
 	}
@@ -59,6 +75,13 @@ func (entries *DirEntries) Next() bool {
 	return true
 }
 
+func (entries *DirEntries) readNext() (*DirEntry, bool, error) {
+	if entries.v2 {
+		return readNextDirListEntryV2(entries.scanner)
+	}
+	return readNextDirListEntry(entries.scanner)
+}
+
 func (entries *DirEntries) Entry() *DirEntry {
 	return entries.currentEntry
 }
@@ -112,8 +135,47 @@ func readNextDirListEntry(s wire.SyncScanner) (entry *DirEntry, done bool, err e
 	entry = &DirEntry{
 		Name:       name,
 		Mode:       mode,
-		Size:       size,
+		Size:       int64(uint32(size)),
 		ModifiedAt: mtime,
 	}
 	return
 }
+
+// readNextDirListEntryV2 reads a DNT2 message. Entries that the device couldn't stat only have
+// their Name set.
+func readNextDirListEntryV2(s wire.SyncScanner) (entry *DirEntry, done bool, err error) {
+	status, err := s.ReadStatus("dir-entry")
+	if err != nil {
+		return
+	}
+
+	// The DONE message has the same layout as an entry, so it's read the same way to consume it.
+	if status == "DONE" {
+		done = true
+	} else if status != "DNT2" {
+		err = errors.Errorf(errors.AssertionError, "error reading dir entries: expected dir entry ID 'DNT2', but got '%s'", status)
+		return
+	}
+
+	errno, err := s.ReadInt32()
+	if err != nil {
+		err = errors.WrapErrf(err, "error reading dir entries: error reading stat error: %v", err)
+		return
+	}
+	entry, err = readStatFieldsV2(s)
+	if err != nil {
+		err = errors.WrapErrf(err, "error reading dir entries: %v", err)
+		return
+	}
+	name, err := s.ReadString()
+	if err != nil {
+		err = errors.WrapErrf(err, "error reading dir entries: error reading file name: %v", err)
+		return
+	}
+
+	if errno != 0 {
+		entry = &DirEntry{}
+	}
+	entry.Name = name
+	return
+}
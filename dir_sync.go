@@ -0,0 +1,422 @@
+package adb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+// SyncOptions configures Sync.
+type SyncOptions struct {
+	TransferOptions
+
+	// Glob patterns that select which files are mirrored, as in DirOptions. Files on the device
+	// that aren't selected are never deleted.
+	Include []string
+	Exclude []string
+
+	// Compare the contents of files that are the same size by hashing them on the device with
+	// sha256sum, instead of comparing modification times.
+	Checksum bool
+	// Delete files and directories on the device that don't exist locally.
+	Delete bool
+	// Only work out what would be changed, without changing anything.
+	DryRun bool
+}
+
+// SyncPlan lists the changes that Sync made, or would make in a dry run. Paths are relative to
+// the root directories, with forward slashes.
+type SyncPlan struct {
+	// Files that don't exist on the device.
+	Added []string
+	// Files on the device that differ from the local ones.
+	Changed []string
+	// Files and directories on the device that don't exist locally, if SyncOptions.Delete is set.
+	// When a whole directory is deleted, only the directory is listed.
+	Deleted []string
+}
+
+/*
+Sync mirrors the directory tree at localDir to remoteDir on the device, only pushing files that are
+missing or different on the device. Files are different if their sizes or modification times differ,
+or, with SyncOptions.Checksum, if their sizes or contents differ.
+
+Only regular files are mirrored, and directories are created on the device as needed to hold them.
+Symlinks and other special files in localDir are ignored.
+
+Sync returns the changes it made. As with PushDir, files that can't be transferred don't stop the
+rest from being transferred, and the returned error has a *DirTransferError cause listing them.
+
+Corresponds to the command:
+
+	adb sync
+*/
+func (c *Device) Sync(ctx context.Context, localDir, remoteDir string, opts SyncOptions) (*SyncPlan, error) {
+	if localDir == "" || remoteDir == "" {
+		return nil, errors.AssertionErrorf("localDir and remoteDir cannot be empty")
+	}
+
+	push := &dirPush{
+		dirTransfer: dirTransfer{opts: DirOptions{
+			TransferOptions: opts.TransferOptions,
+			Include:         opts.Include,
+			Exclude:         opts.Exclude,
+		}},
+		device:      c,
+		localDir:    localDir,
+		remoteDir:   remoteDir,
+		dirModes:    make(map[string]os.FileMode),
+		createdDirs: make(map[string]bool),
+	}
+	defer push.closeSyncSession()
+
+	localFiles, err := push.scanLocal(localDir)
+	if err != nil {
+		return nil, wrapClientError(err, c, "Sync(%s)", remoteDir)
+	}
+	remoteFiles, err := push.scanRemote(ctx, remoteDir)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapClientError(err, c, "Sync(%s)", remoteDir)
+	}
+
+	plan, err := push.plan(ctx, localFiles, remoteFiles, opts)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapClientError(err, c, "Sync(%s)", remoteDir)
+	}
+	if opts.DryRun {
+		return plan, wrapClientError(push.err(), c, "Sync(%s)", remoteDir)
+	}
+
+	deletedDirs := make(map[string]bool)
+	for _, relPath := range plan.Deleted {
+		deletedDirs[relPath] = true
+	}
+	for relPath, entry := range remoteFiles {
+		// Directories that already exist don't need to be created before pushing into them.
+		if entry.Mode.IsDir() && !deletedDirs[relPath] && !hasDeletedAncestor(deletedDirs, relPath) {
+			push.createdDirs[relPath] = true
+		}
+	}
+	if len(plan.Deleted) > 0 {
+		if err := push.deleteRemote(ctx, plan.Deleted); err != nil {
+			push.fail(".", err)
+		}
+	}
+	for _, relPath := range append(append([]string{}, plan.Added...), plan.Changed...) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return plan, ctxErr
+		}
+		push.pushFile(ctx, filepath.Join(localDir, filepath.FromSlash(relPath)), relPath)
+	}
+	return plan, wrapClientError(push.err(), c, "Sync(%s)", remoteDir)
+}
+
+// scanLocal returns the regular files and directories under root, by relative path, including root
+// itself as ".". The directories' permissions are recorded in dirModes.
+func (p *dirPush) scanLocal(root string) (map[string]os.FileInfo, error) {
+	// WalkDir doesn't follow root if it's a symlink.
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, errors.WrapErrorf(err, errors.AssertionError, "error reading %s", root)
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, errors.WrapErrorf(err, errors.AssertionError, "error reading %s", root)
+	}
+	if !info.IsDir() {
+		return nil, errors.AssertionErrorf("%s is not a directory", root)
+	}
+
+	files := make(map[string]os.FileInfo)
+	err = filepath.WalkDir(root, func(localPath string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(root, localPath)
+		if relErr != nil {
+			return relErr
+		}
+		relPath := filepath.ToSlash(rel)
+
+		if err != nil {
+			p.fail(relPath, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath != "." && p.excluded(relPath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && (!d.Type().IsRegular() || !p.included(relPath)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		if d.IsDir() {
+			p.dirModes[relPath] = info.Mode().Perm()
+		}
+		files[relPath] = info
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapErrorf(err, errors.AssertionError, "error reading %s", root)
+	}
+	return files, nil
+}
+
+// scanRemote returns the files and directories under root on the device, by relative path,
+// including root itself as ".". If root doesn't exist, the result is empty.
+func (p *dirPush) scanRemote(ctx context.Context, root string) (map[string]*DirEntry, error) {
+	session, err := p.syncSession(ctx, p.device)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*DirEntry)
+	info, err := session.Stat(root)
+	if HasErrCode(err, FileNoExistError) {
+		return files, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !info.Mode.IsDir() {
+		return nil, errors.AssertionErrorf("%s is not a directory", root)
+	}
+	files["."] = info
+
+	var scan func(dir, relDir string) error
+	scan = func(dir, relDir string) error {
+		entries, err := session.ListDirEntries(dir)
+		if err != nil {
+			return err
+		}
+		all, err := entries.ReadAll()
+		if err != nil {
+			return err
+		}
+		for _, entry := range all {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			relPath := path.Join(relDir, entry.Name)
+			if p.excluded(relPath) {
+				continue
+			}
+			files[relPath] = entry
+			if entry.Mode.IsDir() {
+				if err := scan(path.Join(dir, entry.Name), relPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return files, scan(root, ".")
+}
+
+// plan compares the local and remote trees to work out which files need to be pushed or deleted.
+func (p *dirPush) plan(ctx context.Context, localFiles map[string]os.FileInfo, remoteFiles map[string]*DirEntry, opts SyncOptions) (*SyncPlan, error) {
+	plan := &SyncPlan{}
+	var toHash []string
+
+	var localPaths []string
+	for relPath, local := range localFiles {
+		if !local.IsDir() {
+			localPaths = append(localPaths, relPath)
+		}
+	}
+	sort.Strings(localPaths)
+
+	for _, relPath := range localPaths {
+		local := localFiles[relPath]
+		remote, ok := remoteFiles[relPath]
+		switch {
+		case !ok:
+			plan.Added = append(plan.Added, relPath)
+		case !remote.Mode.IsRegular() || remote.Size != local.Size():
+			plan.Changed = append(plan.Changed, relPath)
+		case opts.Checksum:
+			toHash = append(toHash, relPath)
+		case remote.ModifiedAt.Unix() != local.ModTime().Unix():
+			plan.Changed = append(plan.Changed, relPath)
+		}
+	}
+
+	if len(toHash) > 0 {
+		changed, err := p.changedContents(ctx, toHash)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changed = append(plan.Changed, changed...)
+		sort.Strings(plan.Changed)
+	}
+
+	if opts.Delete {
+		plan.Deleted = p.extraneous(localFiles, remoteFiles)
+	}
+	return plan, nil
+}
+
+// extraneous returns the files and directories on the device that don't exist locally, leaving out
+// the contents of directories that are returned themselves.
+func (p *dirPush) extraneous(localFiles map[string]os.FileInfo, remoteFiles map[string]*DirEntry) []string {
+	var relPaths []string
+	for relPath := range remoteFiles {
+		relPaths = append(relPaths, relPath)
+	}
+	// Parents sort before their children.
+	sort.Strings(relPaths)
+
+	var deleted []string
+	deletedDirs := make(map[string]bool)
+	for _, relPath := range relPaths {
+		remote := remoteFiles[relPath]
+		if local, ok := localFiles[relPath]; ok && local.IsDir() == remote.Mode.IsDir() {
+			continue
+		}
+		if remote.Mode.IsDir() && len(p.opts.Include) > 0 {
+			// The directory may hold files that aren't included, so only its included files are deleted.
+			continue
+		}
+		if !remote.Mode.IsDir() && !p.included(relPath) {
+			continue
+		}
+		if hasDeletedAncestor(deletedDirs, relPath) {
+			continue
+		}
+		deleted = append(deleted, relPath)
+		if remote.Mode.IsDir() {
+			deletedDirs[relPath] = true
+		}
+	}
+	return deleted
+}
+
+func hasDeletedAncestor(deletedDirs map[string]bool, relPath string) bool {
+	for dir := path.Dir(relPath); dir != "."; dir = path.Dir(dir) {
+		if deletedDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// changedContents returns the files, out of relPaths, whose contents on the device differ from
+// the local ones. Files that can't be hashed are assumed to differ.
+func (p *dirPush) changedContents(ctx context.Context, relPaths []string) (changed []string, err error) {
+	remotePaths := make([]string, len(relPaths))
+	for i, relPath := range relPaths {
+		remotePaths[i] = path.Join(p.remoteDir, relPath)
+	}
+
+	start := 0
+	for _, batch := range commandBatches("sha256sum", nil, remotePaths) {
+		// sha256sum fails if any file can't be read, but still prints the other checksums.
+		output, _, err := p.device.runWithStatus(ctx, shellCommandLine("sha256sum", batch...))
+		if err != nil {
+			return nil, err
+		}
+		remoteHashes := parseChecksums(output)
+
+		for i, remotePath := range batch {
+			relPath := relPaths[start+i]
+			localHash, err := p.localChecksum(relPath)
+			if err != nil || localHash != remoteHashes[remotePath] {
+				changed = append(changed, relPath)
+			}
+		}
+		start += len(batch)
+	}
+	return changed, nil
+}
+
+func (p *dirPush) localChecksum(relPath string) (string, error) {
+	file, err := os.Open(filepath.Join(p.localDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseChecksums parses the output of sha256sum into a map of paths to hashes. Lines that aren't
+// hashes, like error messages, are ignored.
+func parseChecksums(output string) map[string]string {
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < sha256.Size*2+2 || line[sha256.Size*2:sha256.Size*2+2] != "  " {
+			continue
+		}
+		hash := line[:sha256.Size*2]
+		if _, err := hex.DecodeString(hash); err != nil {
+			continue
+		}
+		hashes[line[sha256.Size*2+2:]] = strings.ToLower(hash)
+	}
+	return hashes
+}
+
+// deleteRemote deletes the files and directories at relPaths on the device.
+func (p *dirPush) deleteRemote(ctx context.Context, relPaths []string) error {
+	remotePaths := make([]string, len(relPaths))
+	for i, relPath := range relPaths {
+		remotePaths[i] = path.Join(p.remoteDir, relPath)
+	}
+
+	for _, batch := range commandBatches("rm", []string{"-rf", "--"}, remotePaths) {
+		commandLine := shellCommandLine("rm", append([]string{"-rf", "--"}, batch...)...)
+		if _, err := p.device.runFileCommand(ctx, p.remoteDir, commandLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandBatches splits paths into batches that are each small enough to pass to cmd, after
+// fixedArgs, in a single runWithStatus request. A path that's too long on its own still gets a
+// batch, so the request fails instead of the path being skipped.
+func commandBatches(cmd string, fixedArgs, paths []string) [][]string {
+	baseLength := len(statusService(shellCommandLine(cmd, fixedArgs...)))
+
+	var batches [][]string
+	start, length := 0, baseLength
+	for i, p := range paths {
+		argLength := len(" ") + len(shellQuote(p))
+		if i > start && length+argLength > wire.MaxRequestLength {
+			batches = append(batches, paths[start:i])
+			start, length = i, baseLength
+		}
+		length += argLength
+	}
+	if start < len(paths) {
+		batches = append(batches, paths[start:])
+	}
+	return batches
+}
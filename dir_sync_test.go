@@ -0,0 +1,198 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFileInfo is a local file for comparing against the device.
+type fakeFileInfo struct {
+	os.FileInfo
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+func (f fakeFileInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeFileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeFileInfo) Size() int64        { return f.size }
+func (f fakeFileInfo) ModTime() time.Time { return f.modTime }
+
+const helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestParseChecksums(t *testing.T) {
+	output := helloSha256 + "  /sdcard/a b.txt\r\n" +
+		"sha256sum: /sdcard/missing: No such file or directory\n" +
+		"zzzz  /sdcard/bad\n"
+
+	assert.Equal(t, map[string]string{"/sdcard/a b.txt": helloSha256}, parseChecksums(output))
+}
+
+func TestSyncPlan(t *testing.T) {
+	localFiles := map[string]os.FileInfo{
+		".":         fakeFileInfo{mode: os.ModeDir | 0755},
+		"same":      fakeFileInfo{mode: 0644, size: 5, modTime: someTime},
+		"new":       fakeFileInfo{mode: 0644, size: 5, modTime: someTime},
+		"resized":   fakeFileInfo{mode: 0644, size: 6, modTime: someTime},
+		"touched":   fakeFileInfo{mode: 0644, size: 5, modTime: someTime.Add(time.Second)},
+		"dir":       fakeFileInfo{mode: os.ModeDir | 0755},
+		"dir/inner": fakeFileInfo{mode: 0644, size: 5, modTime: someTime},
+	}
+	remoteFiles := map[string]*DirEntry{
+		".":             {Mode: os.ModeDir | 0755},
+		"same":          {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"resized":       {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"touched":       {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"dir":           {Mode: os.ModeDir | 0755},
+		"dir/inner":     {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"dir/old":       {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"olddir":        {Mode: os.ModeDir | 0755},
+		"olddir/a":      {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"olddir/sub":    {Mode: os.ModeDir | 0755},
+		"olddir/sub/b":  {Mode: 0644, Size: 5, ModifiedAt: someTime},
+		"olddir-2.json": {Mode: 0644, Size: 5, ModifiedAt: someTime},
+	}
+	push := &dirPush{}
+
+	plan, err := push.plan(context.Background(), localFiles, remoteFiles, SyncOptions{Delete: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncPlan{
+		Added:   []string{"new"},
+		Changed: []string{"resized", "touched"},
+		Deleted: []string{"dir/old", "olddir", "olddir-2.json"},
+	}, plan)
+
+	plan, err = push.plan(context.Background(), localFiles, remoteFiles, SyncOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Deleted)
+}
+
+func TestSyncPlanDeleteWithInclude(t *testing.T) {
+	localFiles := map[string]os.FileInfo{
+		".": fakeFileInfo{mode: os.ModeDir | 0755},
+	}
+	remoteFiles := map[string]*DirEntry{
+		".":             {Mode: os.ModeDir | 0755},
+		"olddir":        {Mode: os.ModeDir | 0755},
+		"olddir/a.png":  {Mode: 0644},
+		"olddir/b.json": {Mode: 0644},
+	}
+	push := &dirPush{dirTransfer: dirTransfer{opts: DirOptions{Include: []string{"*.png"}}}}
+
+	plan, err := push.plan(context.Background(), localFiles, remoteFiles, SyncOptions{Delete: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"olddir/a.png"}, plan.Deleted)
+}
+
+func TestSyncPlanChecksum(t *testing.T) {
+	localDir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(localDir, "same"), []byte("hello"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(localDir, "edited"), []byte("world"), 0644))
+
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		Messages: []string{
+			helloSha256 + "  /sdcard/dir/edited\n",
+			helloSha256 + "  /sdcard/dir/same\n",
+			"\n0",
+		},
+	}
+	push := &dirPush{
+		device:    (&Adb{s}).Device(DeviceWithSerial("abc")),
+		localDir:  localDir,
+		remoteDir: "/sdcard/dir",
+	}
+	localFiles := map[string]os.FileInfo{
+		"same":   fakeFileInfo{mode: 0644, size: 5, modTime: someTime},
+		"edited": fakeFileInfo{mode: 0644, size: 5, modTime: someTime},
+	}
+	remoteFiles := map[string]*DirEntry{
+		// Modification times are ignored when comparing checksums.
+		"same":   {Mode: 0644, Size: 5, ModifiedAt: someTime.Add(time.Hour)},
+		"edited": {Mode: 0644, Size: 5, ModifiedAt: someTime},
+	}
+
+	plan, err := push.plan(context.Background(), localFiles, remoteFiles, SyncOptions{Checksum: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"edited"}, plan.Changed)
+	assert.Equal(t, `exec:{ sha256sum /sdcard/dir/edited /sdcard/dir/same; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestSyncDeleteRemote(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\n0"},
+	}
+	push := &dirPush{
+		device:    (&Adb{s}).Device(DeviceWithSerial("abc")),
+		remoteDir: "/sdcard/dir",
+	}
+
+	assert.NoError(t, push.deleteRemote(context.Background(), []string{"a", "b c"}))
+	assert.Equal(t, `exec:{ rm -rf -- /sdcard/dir/a '/sdcard/dir/b c'; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestSyncDeleteRemoteBatchesFitInRequests(t *testing.T) {
+	requests := make(chan string, 64)
+	s := pipeServer(func(conn net.Conn) {
+		if acceptRequests(conn, 2, requests) {
+			io.WriteString(conn, "\n0")
+		}
+	})
+	push := &dirPush{
+		device:    (&Adb{s}).Device(DeviceWithSerial("abc")),
+		remoteDir: "/sdcard/Android/data/com.example.app/files",
+	}
+	var relPaths []string
+	for i := 0; i < 200; i++ {
+		relPaths = append(relPaths, fmt.Sprintf("assets/textures/texture_%03d.png", i))
+	}
+
+	// The pipe server's client uses the real sender, which rejects requests that are too long.
+	require.NoError(t, push.deleteRemote(context.Background(), relPaths))
+	close(requests)
+
+	var deleted []string
+	for req := range requests {
+		if req == "host:transport:abc" {
+			continue
+		}
+		assert.True(t, len(req) <= wire.MaxRequestLength, "request is %d bytes", len(req))
+		commandLine := strings.TrimSuffix(strings.TrimPrefix(req, "exec:{ rm -rf -- "), "; } 2>&1; printf '\\n%d' $?")
+		deleted = append(deleted, strings.Fields(commandLine)...)
+	}
+	require.Len(t, deleted, len(relPaths))
+	assert.Equal(t, "/sdcard/Android/data/com.example.app/files/assets/textures/texture_000.png", deleted[0])
+	assert.Equal(t, "/sdcard/Android/data/com.example.app/files/assets/textures/texture_199.png", deleted[199])
+}
+
+func TestCommandBatches(t *testing.T) {
+	var paths []string
+	for i := 0; i < 300; i++ {
+		paths = append(paths, fmt.Sprintf("/sdcard/Android/data/com.example.app/files/it's %03d.png", i))
+	}
+
+	batches := commandBatches("sha256sum", nil, paths)
+	assert.True(t, len(batches) > 1)
+	var joined []string
+	for _, batch := range batches {
+		sender := wire.NewSender(nopWriteCloser{ioutil.Discard})
+		assert.NoError(t, wire.SendMessageString(sender, statusService(shellCommandLine("sha256sum", batch...))))
+		joined = append(joined, batch...)
+	}
+	assert.Equal(t, paths, joined)
+
+	assert.Empty(t, commandBatches("rm", []string{"-rf", "--"}, nil))
+}
@@ -0,0 +1,690 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// SymlinkPolicy controls what PushDir and PullDir do with symbolic links.
+type SymlinkPolicy int
+
+const (
+	// Don't transfer symlinks. This is the default.
+	SymlinkSkip SymlinkPolicy = iota
+	// Transfer the file or directory the link points to, as if it were in the link's place.
+	SymlinkFollow
+	// Recreate the link itself, with the same target.
+	SymlinkPreserve
+)
+
+// DirOptions configures PushDir and PullDir.
+type DirOptions struct {
+	TransferOptions
+
+	// What to do with symlinks found in the tree. The root directory is always followed.
+	Symlinks SymlinkPolicy
+
+	// Glob patterns, in path.Match syntax, that select which files are transferred. Patterns
+	// containing a slash are matched against the path relative to the root directory, using
+	// forward slashes on all platforms. Other patterns are matched against the file's name.
+	//
+	// If Include is not empty, only files matching at least one of its patterns are transferred.
+	// It doesn't apply to directories, which are always searched.
+	Include []string
+	// Files and directories matching any of these patterns are not transferred, and excluded
+	// directories aren't searched.
+	Exclude []string
+}
+
+// FileTransferError describes a single file that PushDir or PullDir couldn't transfer.
+type FileTransferError struct {
+	// Path of the file relative to the root directory, with forward slashes.
+	Path string
+	Err  error
+}
+
+func (e FileTransferError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e FileTransferError) Unwrap() error {
+	return e.Err
+}
+
+/*
+DirTransferError is the cause of an error returned when PushDir or PullDir couldn't transfer some
+of the files in a tree. The rest of the tree was still transferred.
+Use errors.As to get it from the returned error.
+*/
+type DirTransferError struct {
+	Files []FileTransferError
+}
+
+func (e *DirTransferError) Error() string {
+	if len(e.Files) == 1 {
+		return fmt.Sprintf("failed to transfer %s", e.Files[0])
+	}
+	return fmt.Sprintf("failed to transfer %d files, first: %s", len(e.Files), e.Files[0])
+}
+
+// dirTransfer holds the state shared by the files of a single PushDir or PullDir.
+type dirTransfer struct {
+	opts   DirOptions
+	failed []FileTransferError
+
+	// Runs the sync operations for the whole tree, to avoid opening a connection for each file.
+	session *SyncSession
+}
+
+// syncSession returns the session to run sync operations on. If an operation failed and left the
+// previous session unusable, a new one is opened so one bad file doesn't fail the rest of the tree.
+func (t *dirTransfer) syncSession(ctx context.Context, device *Device) (*SyncSession, error) {
+	if t.session != nil && t.session.err == nil {
+		return t.session, nil
+	}
+	session, err := device.OpenSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.session = session
+	return session, nil
+}
+
+func (t *dirTransfer) closeSyncSession() {
+	if t.session != nil {
+		t.session.Close()
+	}
+}
+
+func (t *dirTransfer) fail(relPath string, err error) {
+	t.failed = append(t.failed, FileTransferError{Path: relPath, Err: err})
+}
+
+// err returns an error wrapping a *DirTransferError if any files failed. The error has the code of
+// the first failure if it came from the library, else AdbError.
+func (t *dirTransfer) err() error {
+	if len(t.failed) == 0 {
+		return nil
+	}
+	code := errors.AdbError
+	if first, ok := t.failed[0].Err.(*errors.Err); ok {
+		code = first.Code
+	}
+	return errors.WrapErrorf(&DirTransferError{Files: t.failed}, code, "%d files failed to transfer", len(t.failed))
+}
+
+// excluded returns true if the file at relPath should be skipped entirely.
+func (t *dirTransfer) excluded(relPath string) bool {
+	return matchesAnyGlob(t.opts.Exclude, relPath)
+}
+
+// included returns true if the regular file or link at relPath should be transferred.
+func (t *dirTransfer) included(relPath string) bool {
+	return len(t.opts.Include) == 0 || matchesAnyGlob(t.opts.Include, relPath)
+}
+
+func matchesAnyGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(relPath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+PushDir copies the directory tree at localDir to remoteDir on the device, creating remoteDir and any
+directories under it as needed. Files and directories keep their permission bits and modification
+times.
+
+Files that can't be read or written don't stop the rest of the tree from being transferred. If any
+failed, the returned error has a *DirTransferError cause listing them. If ctx is done, the transfer
+stops and ctx.Err() is returned.
+
+Corresponds to the command:
+
+	adb push <localDir>/. <remoteDir>
+*/
+func (c *Device) PushDir(ctx context.Context, localDir, remoteDir string, opts DirOptions) error {
+	if localDir == "" || remoteDir == "" {
+		return errors.AssertionErrorf("localDir and remoteDir cannot be empty")
+	}
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return wrapClientError(errors.WrapErrorf(err, errors.AssertionError, "error reading %s", localDir),
+			c, "PushDir(%s)", remoteDir)
+	}
+	if !info.IsDir() {
+		return wrapClientError(errors.AssertionErrorf("%s is not a directory", localDir),
+			c, "PushDir(%s)", remoteDir)
+	}
+
+	push := &dirPush{
+		dirTransfer: dirTransfer{opts: opts},
+		device:      c,
+		localDir:    localDir,
+		remoteDir:   remoteDir,
+		dirModes:    map[string]os.FileMode{".": info.Mode().Perm()},
+		dirModTimes: map[string]time.Time{".": info.ModTime()},
+		createdDirs: make(map[string]bool),
+	}
+	defer push.closeSyncSession()
+	if err := push.walk(ctx, localDir, ".", map[string]bool{}); err != nil {
+		return err
+	}
+	push.finishDirs(ctx)
+	return wrapClientError(push.err(), c, "PushDir(%s)", remoteDir)
+}
+
+type dirPush struct {
+	dirTransfer
+	device    *Device
+	localDir  string
+	remoteDir string
+
+	// Permissions and modification times of each local directory found so far, by relative path.
+	dirModes    map[string]os.FileMode
+	dirModTimes map[string]time.Time
+	// Relative paths of directories that have been created on the device.
+	createdDirs map[string]bool
+}
+
+// walk pushes the contents of the local directory root, which is at relRoot in the tree.
+// visited holds the real paths of the directories being walked, to break symlink cycles.
+func (p *dirPush) walk(ctx context.Context, root, relRoot string, visited map[string]bool) error {
+	// WalkDir doesn't follow root if it's a symlink.
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		p.fail(relRoot, err)
+		return nil
+	}
+	if visited[root] {
+		p.fail(relRoot, errors.Errorf(errors.AssertionError, "symlink cycle"))
+		return nil
+	}
+	visited[root] = true
+	defer delete(visited, root)
+
+	return filepath.WalkDir(root, func(localPath string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		rel, relErr := filepath.Rel(root, localPath)
+		if relErr != nil {
+			return relErr
+		}
+		relPath := path.Join(relRoot, filepath.ToSlash(rel))
+
+		if err != nil {
+			p.fail(relPath, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if relPath != relRoot && p.excluded(relPath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case d.IsDir():
+			return p.visitDir(ctx, relPath, d)
+		case d.Type()&fs.ModeSymlink != 0:
+			return p.visitSymlink(ctx, localPath, relPath, visited)
+		case d.Type().IsRegular():
+			if p.included(relPath) {
+				p.pushFile(ctx, localPath, relPath)
+			}
+		default:
+			p.fail(relPath, errors.Errorf(errors.AssertionError, "unsupported file type: %s", d.Type()))
+		}
+		return nil
+	})
+}
+
+func (p *dirPush) visitDir(ctx context.Context, relPath string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		p.fail(relPath, err)
+		return filepath.SkipDir
+	}
+	p.dirModes[relPath] = info.Mode().Perm()
+	p.dirModTimes[relPath] = info.ModTime()
+
+	// Without any include patterns every directory is transferred, even empty ones. Otherwise
+	// directories are only created when a file is pushed into them.
+	if len(p.opts.Include) == 0 {
+		if err := p.ensureDir(ctx, relPath); err != nil {
+			p.fail(relPath, err)
+			return filepath.SkipDir
+		}
+	}
+	return nil
+}
+
+func (p *dirPush) visitSymlink(ctx context.Context, localPath, relPath string, visited map[string]bool) error {
+	switch p.opts.Symlinks {
+	case SymlinkFollow:
+		info, err := os.Stat(localPath)
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		if info.IsDir() {
+			return p.walk(ctx, localPath, relPath, visited)
+		}
+		if p.included(relPath) {
+			p.pushFile(ctx, localPath, relPath)
+		}
+	case SymlinkPreserve:
+		if !p.included(relPath) {
+			return nil
+		}
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		if err := p.ensureDir(ctx, path.Dir(relPath)); err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		remotePath := path.Join(p.remoteDir, relPath)
+		if _, err := p.device.runFileCommand(ctx, remotePath, shellCommandLine("ln", "-sfn", "--", filepath.ToSlash(target), remotePath)); err != nil {
+			p.fail(relPath, err)
+		}
+	}
+	return nil
+}
+
+func (p *dirPush) pushFile(ctx context.Context, localPath, relPath string) {
+	if err := p.ensureDir(ctx, path.Dir(relPath)); err != nil {
+		p.fail(relPath, err)
+		return
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		p.fail(relPath, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		p.fail(relPath, err)
+		return
+	}
+
+	session, err := p.syncSession(ctx, p.device)
+	if err != nil {
+		p.fail(relPath, err)
+		return
+	}
+	remotePath := path.Join(p.remoteDir, relPath)
+	writer, err := session.OpenWriteWithOptions(remotePath, info.Mode().Perm(), info.ModTime(), p.opts.withoutMeter())
+	if err != nil {
+		p.fail(relPath, err)
+		return
+	}
+	src := p.opts.meterReader(ctx, file, remotePath, 0, info.Size())
+	if _, err := io.Copy(writer, src); err != nil {
+		writer.Close()
+		p.fail(relPath, err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		p.fail(relPath, err)
+	}
+}
+
+// ensureDir creates the directory at relPath on the device, and any of its parents, unless they've
+// already been created. They're writable by the owner until finishDirs is called, so their
+// contents can be pushed.
+func (p *dirPush) ensureDir(ctx context.Context, relPath string) error {
+	if p.createdDirs[relPath] {
+		return nil
+	}
+	if relPath != "." {
+		if err := p.ensureDir(ctx, path.Dir(relPath)); err != nil {
+			return err
+		}
+	}
+
+	if err := p.device.MkdirAll(ctx, path.Join(p.remoteDir, relPath), p.dirModes[relPath]|0700); err != nil {
+		return err
+	}
+	p.createdDirs[relPath] = true
+	return nil
+}
+
+// finishDirs sets the permissions and modification times of the created directories once all the
+// files have been pushed, deepest first so setting them on a directory isn't undone by changing
+// its children.
+func (p *dirPush) finishDirs(ctx context.Context) {
+	relPaths := make([]string, 0, len(p.createdDirs))
+	for relPath := range p.createdDirs {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(relPaths)))
+
+	for _, relPath := range relPaths {
+		remotePath := path.Join(p.remoteDir, relPath)
+		if mode := p.dirModes[relPath]; mode != mode|0700 {
+			// Otherwise ensureDir already created it with the right permissions.
+			if err := p.device.Chmod(ctx, remotePath, mode); err != nil {
+				p.fail(relPath, err)
+				continue
+			}
+		}
+		if mtime, ok := p.dirModTimes[relPath]; ok {
+			if err := p.device.setModTime(ctx, remotePath, mtime); err != nil {
+				p.fail(relPath, err)
+			}
+		}
+	}
+}
+
+/*
+PullDir copies the directory tree at remoteDir on the device to localDir, creating localDir and any
+directories under it as needed. Files and directories keep their permission bits and modification
+times.
+
+Files that can't be read or written don't stop the rest of the tree from being transferred. If any
+failed, the returned error has a *DirTransferError cause listing them. If ctx is done, the transfer
+stops and ctx.Err() is returned.
+
+Corresponds to the command:
+
+	adb pull <remoteDir>/. <localDir>
+*/
+func (c *Device) PullDir(ctx context.Context, remoteDir, localDir string, opts DirOptions) error {
+	if remoteDir == "" || localDir == "" {
+		return errors.AssertionErrorf("remoteDir and localDir cannot be empty")
+	}
+	info, err := c.StatContext(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+	if info.Mode&os.ModeSymlink != 0 {
+		// The root is always followed, like PushDir does.
+		if info, err = c.statFollowingLinks(ctx, remoteDir); err != nil {
+			return wrapClientError(err, c, "PullDir(%s)", remoteDir)
+		}
+	}
+	if !info.Mode.IsDir() {
+		return wrapClientError(errors.AssertionErrorf("%s is not a directory", remoteDir),
+			c, "PullDir(%s)", remoteDir)
+	}
+
+	pull := &dirPull{
+		dirTransfer: dirTransfer{opts: opts},
+		device:      c,
+		localDir:    localDir,
+		dirEntries:  map[string]*DirEntry{".": info},
+		createdDirs: make(map[string]*DirEntry),
+	}
+	defer pull.closeSyncSession()
+	if len(opts.Include) == 0 {
+		if err := pull.ensureDir("."); err != nil {
+			return wrapClientError(errors.WrapErrorf(err, errors.AssertionError, "error creating %s", localDir),
+				c, "PullDir(%s)", remoteDir)
+		}
+	}
+	visited := map[string]bool{path.Clean(remoteDir): true}
+	if err := pull.walk(ctx, remoteDir, ".", visited); err != nil {
+		return err
+	}
+	pull.finishDirs()
+	return wrapClientError(pull.err(), c, "PullDir(%s)", remoteDir)
+}
+
+type dirPull struct {
+	dirTransfer
+	device   *Device
+	localDir string
+
+	// Attributes of each remote directory found so far, by relative path.
+	dirEntries map[string]*DirEntry
+	// Directories that have been created locally, by relative path.
+	createdDirs map[string]*DirEntry
+}
+
+// walk pulls the contents of the remote directory root, which is at relRoot in the tree.
+// visited holds the paths of the directories being walked that were reached through symlinks,
+// to break cycles.
+func (p *dirPull) walk(ctx context.Context, root, relRoot string, visited map[string]bool) error {
+	entries, err := p.listDir(ctx, root)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		p.fail(relRoot, err)
+		return nil
+	}
+	return p.visitEntries(ctx, root, relRoot, entries, visited)
+}
+
+func (p *dirPull) visitEntries(ctx context.Context, root, relRoot string, entries []*DirEntry, visited map[string]bool) error {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	for _, entry := range entries {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		remotePath := path.Join(root, entry.Name)
+		relPath := path.Join(relRoot, entry.Name)
+		if p.excluded(relPath) {
+			continue
+		}
+
+		var err error
+		switch {
+		case entry.Mode.IsDir():
+			err = p.visitDir(ctx, remotePath, relPath, entry, visited)
+		case entry.Mode&os.ModeSymlink != 0:
+			err = p.visitSymlink(ctx, remotePath, relPath, visited)
+		case entry.Mode.IsRegular():
+			if p.included(relPath) {
+				p.pullFile(ctx, remotePath, relPath, entry)
+			}
+		default:
+			p.fail(relPath, errors.Errorf(errors.AssertionError, "unsupported file type: %s", entry.Mode.Type()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *dirPull) visitDir(ctx context.Context, remotePath, relPath string, entry *DirEntry, visited map[string]bool) error {
+	p.dirEntries[relPath] = entry
+	if len(p.opts.Include) == 0 {
+		if err := p.ensureDir(relPath); err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+	}
+	return p.walk(ctx, remotePath, relPath, visited)
+}
+
+func (p *dirPull) visitSymlink(ctx context.Context, remotePath, relPath string, visited map[string]bool) error {
+	switch p.opts.Symlinks {
+	case SymlinkFollow:
+		target, err := p.device.resolveLink(ctx, remotePath)
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		entry, err := p.stat(ctx, target)
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		if entry.Mode.IsDir() {
+			if visited[target] {
+				p.fail(relPath, errors.Errorf(errors.AssertionError, "symlink cycle"))
+				return nil
+			}
+			visited[target] = true
+			defer delete(visited, target)
+			return p.visitDir(ctx, target, relPath, entry, visited)
+		}
+		if p.included(relPath) {
+			p.pullFile(ctx, target, relPath, entry)
+		}
+	case SymlinkPreserve:
+		if !p.included(relPath) {
+			return nil
+		}
+		target, err := p.device.Readlink(ctx, remotePath)
+		if err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		if err := p.ensureDir(path.Dir(relPath)); err != nil {
+			p.fail(relPath, err)
+			return nil
+		}
+		localPath := p.localPath(relPath)
+		os.Remove(localPath)
+		if err := os.Symlink(filepath.FromSlash(target), localPath); err != nil {
+			p.fail(relPath, err)
+		}
+	}
+	return nil
+}
+
+func (p *dirPull) pullFile(ctx context.Context, remotePath, relPath string, entry *DirEntry) {
+	if err := p.ensureDir(path.Dir(relPath)); err != nil {
+		p.fail(relPath, err)
+		return
+	}
+
+	localPath := p.localPath(relPath)
+	if err := p.pullFileTo(ctx, remotePath, localPath, entry); err != nil {
+		p.fail(relPath, err)
+		return
+	}
+	if err := os.Chtimes(localPath, entry.ModifiedAt, entry.ModifiedAt); err != nil {
+		p.fail(relPath, err)
+	}
+}
+
+func (p *dirPull) pullFileTo(ctx context.Context, remotePath, localPath string, entry *DirEntry) error {
+	session, err := p.syncSession(ctx, p.device)
+	if err != nil {
+		return err
+	}
+	reader, err := session.OpenReadWithOptions(remotePath, p.opts.withoutMeter())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	src := p.opts.meterReader(ctx, reader, remotePath, 0, entry.Size)
+
+	file, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// OpenFile doesn't change the permissions of existing files, and they're masked by the umask.
+	return os.Chmod(localPath, entry.Mode.Perm())
+}
+
+// ensureDir creates the directory at relPath locally, and any of its parents, unless they've
+// already been created. They're writable by the owner until finishDirs is called, so their
+// contents can be pulled.
+func (p *dirPull) ensureDir(relPath string) error {
+	if _, ok := p.createdDirs[relPath]; ok {
+		return nil
+	}
+	if relPath != "." {
+		if err := p.ensureDir(path.Dir(relPath)); err != nil {
+			return err
+		}
+	}
+
+	entry := p.dirEntries[relPath]
+	if err := os.MkdirAll(p.localPath(relPath), entry.Mode.Perm()|0700); err != nil {
+		return err
+	}
+	p.createdDirs[relPath] = entry
+	return nil
+}
+
+// finishDirs sets the permissions and modification times of the created directories, deepest
+// first so setting them on a directory isn't undone by changing its children.
+func (p *dirPull) finishDirs() {
+	relPaths := make([]string, 0, len(p.createdDirs))
+	for relPath := range p.createdDirs {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(relPaths)))
+
+	for _, relPath := range relPaths {
+		entry := p.createdDirs[relPath]
+		localPath := p.localPath(relPath)
+		if err := os.Chmod(localPath, entry.Mode.Perm()); err != nil {
+			p.fail(relPath, err)
+			continue
+		}
+		if err := os.Chtimes(localPath, entry.ModifiedAt, entry.ModifiedAt); err != nil {
+			p.fail(relPath, err)
+		}
+	}
+}
+
+func (p *dirPull) listDir(ctx context.Context, remotePath string) ([]*DirEntry, error) {
+	session, err := p.syncSession(ctx, p.device)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := session.ListDirEntries(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return entries.ReadAll()
+}
+
+func (p *dirPull) stat(ctx context.Context, remotePath string) (*DirEntry, error) {
+	session, err := p.syncSession(ctx, p.device)
+	if err != nil {
+		return nil, err
+	}
+	return session.Stat(remotePath)
+}
+
+func (p *dirPull) localPath(relPath string) string {
+	return filepath.Join(p.localDir, filepath.FromSlash(relPath))
+}
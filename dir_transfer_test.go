@@ -0,0 +1,121 @@
+package adb
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drtechco/goadb/internal/errors"
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchesAnyGlob(t *testing.T) {
+	patterns := []string{"*.apk", "build/*"}
+
+	assert.True(t, matchesAnyGlob(patterns, "app.apk"))
+	assert.True(t, matchesAnyGlob(patterns, "out/debug/app.apk"))
+	assert.True(t, matchesAnyGlob(patterns, "build/output"))
+	assert.False(t, matchesAnyGlob(patterns, "src/build/output"))
+	assert.False(t, matchesAnyGlob(patterns, "app.apk.txt"))
+	assert.False(t, matchesAnyGlob(nil, "app.apk"))
+}
+
+func TestDirTransferIncluded(t *testing.T) {
+	all := dirTransfer{}
+	assert.True(t, all.included("a/b.txt"))
+
+	some := dirTransfer{opts: DirOptions{Include: []string{"*.txt"}, Exclude: []string{"tmp"}}}
+	assert.True(t, some.included("a/b.txt"))
+	assert.False(t, some.included("a/b.bin"))
+	assert.True(t, some.excluded("a/tmp"))
+	assert.False(t, some.excluded("a/tmp/b.txt"))
+}
+
+func TestDirTransferErr(t *testing.T) {
+	transfer := dirTransfer{}
+	assert.NoError(t, transfer.err())
+
+	transfer.fail("a.txt", errors.Errorf(errors.FileNoExistError, "gone"))
+	transfer.fail("b.txt", os.ErrPermission)
+	err := transfer.err()
+	assert.True(t, HasErrCode(err, FileNoExistError))
+
+	var dirErr *DirTransferError
+	require.True(t, stderrors.As(err, &dirErr))
+	assert.Len(t, dirErr.Files, 2)
+	assert.Equal(t, "b.txt", dirErr.Files[1].Path)
+	assert.True(t, stderrors.Is(dirErr.Files[1], os.ErrPermission))
+	assert.Equal(t, "failed to transfer 2 files, first: a.txt: FileNoExistError: gone", dirErr.Error())
+}
+
+func TestDirPushEnsureDir(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	push := &dirPush{
+		device:      (&Adb{s}).Device(DeviceWithSerial("abc")),
+		remoteDir:   "/sdcard/my dir",
+		dirModes:    map[string]os.FileMode{".": 0755, "a": 0700, "a/b": 0550},
+		createdDirs: map[string]bool{".": true},
+	}
+
+	// Each command reads all the remaining messages, so the exit status is added for each one.
+	s.Messages = append(s.Messages, "\n0")
+	assert.NoError(t, push.ensureDir(context.Background(), "a"))
+	s.Messages = append(s.Messages, "\n0")
+	assert.NoError(t, push.ensureDir(context.Background(), "a/b"))
+	assert.NoError(t, push.ensureDir(context.Background(), "a"))
+	assert.Equal(t, []string{
+		"host:transport:abc", `exec:{ mkdir -p -m 700 -- '/sdcard/my dir/a'; } 2>&1; printf '\n%d' $?`,
+		"host:transport:abc", `exec:{ mkdir -p -m 750 -- '/sdcard/my dir/a/b'; } 2>&1; printf '\n%d' $?`,
+	}, s.Requests)
+}
+
+func TestDirPushFinishDirs(t *testing.T) {
+	requests := make(chan string, 8)
+	server := pipeServer(func(conn net.Conn) {
+		if acceptRequests(conn, 2, requests) {
+			io.WriteString(conn, "\n0")
+		}
+	})
+	push := &dirPush{
+		device:      (&Adb{server}).Device(DeviceWithSerial("abc")),
+		remoteDir:   "/sdcard/dir",
+		dirModes:    map[string]os.FileMode{".": 0755, "a": 0700, "a/b": 0550},
+		dirModTimes: map[string]time.Time{".": someTime, "a": someTime, "a/b": someTime},
+		createdDirs: map[string]bool{".": true, "a": true, "a/b": true},
+	}
+
+	push.finishDirs(context.Background())
+	assert.NoError(t, push.err())
+	close(requests)
+	var execs []string
+	for req := range requests {
+		if req != "host:transport:abc" {
+			execs = append(execs, req)
+		}
+	}
+	assert.Equal(t, []string{
+		`exec:{ chmod 550 -- /sdcard/dir/a/b; } 2>&1; printf '\n%d' $?`,
+		`exec:{ touch -c -m -d @1430640488 -- /sdcard/dir/a/b; } 2>&1; printf '\n%d' $?`,
+		`exec:{ touch -c -m -d @1430640488 -- /sdcard/dir/a; } 2>&1; printf '\n%d' $?`,
+		`exec:{ touch -c -m -d @1430640488 -- /sdcard/dir; } 2>&1; printf '\n%d' $?`,
+	}, execs)
+}
+
+func TestPushDirNotDirectory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, ioutil.WriteFile(file, []byte("hello"), 0644))
+	client := (&Adb{&MockServer{}}).Device(DeviceWithSerial("abc"))
+
+	err := client.PushDir(context.Background(), file, "/sdcard/dir", DirOptions{})
+	assert.True(t, HasErrCode(err, AssertionError))
+}
@@ -0,0 +1,93 @@
+package adb
+
+import (
+	"context"
+	"io"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+/*
+Exec runs a command on the device using the exec service, and returns a reader for its output.
+
+Unlike RunCommand, the output is never passed through a terminal, so it's binary-safe: line
+endings aren't rewritten, and stdout can be streamed as it's produced, e.g. from "screencap -p"
+or "tar". However, the exec service sends stderr on the same stream, so any errors the command
+writes are mixed into the output. Commands whose output must stay clean should discard or redirect
+their stderr, e.g. by passing "screencap -p 2>/dev/null" as cmd.
+
+The arguments are quoted for the device's shell, so they may contain any characters. cmd is not
+quoted, so it can contain its own arguments and redirections.
+
+The command ends when the returned reader is closed, or ctx is done.
+
+Corresponds to the command:
+
+	adb exec-out <command> [args...]
+*/
+func (c *Device) Exec(ctx context.Context, cmd string, args ...string) (io.ReadCloser, error) {
+	conn, err := c.openExec(ctx, cmd, args)
+	if err != nil {
+		return nil, wrapClientError(err, c, "Exec(%s)", cmd)
+	}
+	return conn, nil
+}
+
+/*
+ExecIn is like Exec, but also copies stdin to the command's input in the background.
+
+When stdin is exhausted, the connection is closed for writing so the command sees the end of its
+input. Older adb servers close the whole connection instead, in which case any output the command
+writes after that is lost. If copying stdin fails, the connection is closed and the error is returned
+from reading the output.
+
+Corresponds to the command:
+
+	adb exec-in <command> [args...]
+*/
+func (c *Device) ExecIn(ctx context.Context, stdin io.Reader, cmd string, args ...string) (io.ReadCloser, error) {
+	conn, err := c.openExec(ctx, cmd, args)
+	if err != nil {
+		return nil, wrapClientError(err, c, "ExecIn(%s)", cmd)
+	}
+
+	output, outputWriter := io.Pipe()
+	go func() {
+		_, err := io.Copy(outputWriter, conn)
+		outputWriter.CloseWithError(err)
+	}()
+
+	go func() {
+		if _, err := io.Copy(conn, stdin); err != nil {
+			err = errors.WrapErrorf(err, errors.NetworkError, "error writing stdin")
+			outputWriter.CloseWithError(wrapClientError(err, c, "ExecIn(%s)", cmd))
+			conn.Close()
+			return
+		}
+		if err := conn.CloseWrite(); err != nil {
+			conn.Close()
+		}
+	}()
+
+	return execInOutput{output, conn}, nil
+}
+
+func (c *Device) openExec(ctx context.Context, cmd string, args []string) (*wire.Conn, error) {
+	if isBlank(cmd) {
+		return nil, errors.AssertionErrorf("command cannot be empty")
+	}
+	return c.openService(ctx, "exec:"+shellCommandLine(cmd, args...))
+}
+
+// execInOutput reads the output of a command started with ExecIn, and closes the connection when
+// it's closed.
+type execInOutput struct {
+	*io.PipeReader
+	conn *wire.Conn
+}
+
+func (r execInOutput) Close() error {
+	r.PipeReader.Close()
+	return r.conn.Close()
+}
@@ -0,0 +1,71 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\x89PNG\r\n\x1a\n", "\x00\n\r\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	output, err := client.Exec(context.Background(), "screencap", "-p")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(output)
+	assert.NoError(t, err)
+	assert.NoError(t, output.Close())
+
+	assert.Equal(t, []byte("\x89PNG\r\n\x1a\n\x00\n\r\n"), data)
+	assert.Equal(t, []string{"host:transport:abc", "exec:screencap -p"}, s.Requests)
+}
+
+func TestExecQuotesArgs(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	output, err := client.Exec(context.Background(), "cat", "/sdcard/it's here")
+	require.NoError(t, err)
+	output.Close()
+	assert.Equal(t, `exec:cat '/sdcard/it'\''s here'`, s.Requests[1])
+}
+
+func TestExecEmptyCommand(t *testing.T) {
+	client := (&Adb{&MockServer{}}).Device(DeviceWithSerial("abc"))
+	_, err := client.Exec(context.Background(), " ")
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestExecIn(t *testing.T) {
+	requests := make(chan string, 2)
+	s := newTCPServer(t, func(conn net.Conn) {
+		if acceptRequests(conn, 2, requests) {
+			// Only reply once stdin has been closed.
+			input, _ := ioutil.ReadAll(conn)
+			conn.Write(bytes.ToUpper(input))
+		}
+	})
+
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	output, err := client.ExecIn(context.Background(), strings.NewReader("hello\n"), "tr", "a-z", "A-Z")
+	require.NoError(t, err)
+	defer output.Close()
+
+	data, err := ioutil.ReadAll(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\n", string(data))
+	assert.Equal(t, "host:transport:abc", <-requests)
+	assert.Equal(t, "exec:tr a-z A-Z", <-requests)
+}
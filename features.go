@@ -0,0 +1,183 @@
+package adb
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Feature is a capability reported by the adb server or a device, like "shell_v2".
+type Feature string
+
+// Features that the library knows about. Devices and servers may report others, which are still
+// included in FeatureSets.
+const (
+	// The shell protocol, with separate stdout and stderr, exit codes and stdin.
+	FeatureShell2 Feature = "shell_v2"
+	// The cmd command, which talks to system services directly, and supports streamed installs.
+	FeatureCmd Feature = "cmd"
+	// LST2 and STA2 sync messages, with 64-bit sizes, more attributes and errors.
+	FeatureStat2 Feature = "stat_v2"
+	// LIS2 sync messages, with the same attributes as stat_v2 for each directory entry.
+	FeatureLs2 Feature = "ls_v2"
+	// The server is using libusb instead of the native USB backend.
+	FeatureLibusb Feature = "libusb"
+	// Pushing to a directory that doesn't exist creates it.
+	FeatureFixedPushMkdir Feature = "fixed_push_mkdir"
+	// The device supports installing APEX packages.
+	FeatureApex Feature = "apex"
+	// The abb service, a faster way to run binder commands than cmd.
+	FeatureAbb Feature = "abb"
+	// Pushing symlinks preserves their modification times.
+	FeatureFixedPushSymlinkTimestamp Feature = "fixed_push_symlink_timestamp"
+	// The abb_exec service, like abb but binary-safe.
+	FeatureAbbExec Feature = "abb_exec"
+	// adb remount is implemented by the device, with "remount" in a shell.
+	FeatureRemountShell Feature = "remount_shell"
+	// The track-app service, which reports debuggable and profileable processes.
+	FeatureTrackApp Feature = "track_app"
+	// RCV2 and SND2 sync messages, which take flags.
+	FeatureSendRecv2 Feature = "sendrecv_v2"
+	// Brotli compression for RCV2 and SND2.
+	FeatureSendRecv2Brotli Feature = "sendrecv_v2_brotli"
+	// LZ4 compression for RCV2 and SND2.
+	FeatureSendRecv2LZ4 Feature = "sendrecv_v2_lz4"
+	// Zstandard compression for RCV2 and SND2.
+	FeatureSendRecv2Zstd Feature = "sendrecv_v2_zstd"
+	// SND2 can be asked to discard the data, to measure transfer speed.
+	FeatureSendRecv2DryRunSend Feature = "sendrecv_v2_dry_run_send"
+	// Flow control for adb packets, which speeds up transfers over high-latency connections.
+	FeatureDelayedAck Feature = "delayed_ack"
+	// The server uses the openscreen mDNS implementation.
+	FeatureOpenscreenMdns Feature = "openscreen_mdns"
+	// The dev-raw service, a raw connection to adbd's stdin and stdout.
+	FeatureDevRaw Feature = "devraw"
+	// The app_info service, which reports information about installed apps.
+	FeatureAppInfo Feature = "app_info"
+	// The server_status host service.
+	FeatureServerStatus Feature = "server_status"
+)
+
+// FeatureSet is the set of features reported by the adb server or a device.
+type FeatureSet map[Feature]bool
+
+// Has returns true if feature is in the set.
+func (s FeatureSet) Has(feature Feature) bool {
+	return s[feature]
+}
+
+// String returns the features in the set in the format adb uses: sorted and comma-separated.
+func (s FeatureSet) String() string {
+	features := make([]string, 0, len(s))
+	for feature, ok := range s {
+		if ok {
+			features = append(features, string(feature))
+		}
+	}
+	sort.Strings(features)
+	return strings.Join(features, ",")
+}
+
+func parseFeatureSet(resp string) FeatureSet {
+	features := make(FeatureSet)
+	for _, feature := range strings.Split(resp, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			features[Feature(feature)] = true
+		}
+	}
+	return features
+}
+
+/*
+HostFeatures returns the features supported by the adb server.
+
+Corresponds to the command:
+
+	adb host-features
+*/
+func (c *Adb) HostFeatures() (FeatureSet, error) {
+	return c.HostFeaturesContext(context.Background())
+}
+
+// HostFeaturesContext is like HostFeatures, but gives up when ctx is done.
+func (c *Adb) HostFeaturesContext(ctx context.Context) (FeatureSet, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:host-features")
+	if err != nil {
+		return nil, wrapClientError(err, c, "HostFeatures")
+	}
+	return parseFeatureSet(string(resp)), nil
+}
+
+/*
+Features returns the features supported by both the device and the adb server, which the library
+uses to choose between protocol versions.
+
+The features are cached by the Device until an operation fails because the device or the server
+disconnected, since a device that reconnects, e.g. after rebooting into recovery, may support
+different features.
+
+Corresponds to the command:
+
+	adb features
+*/
+func (c *Device) Features() (FeatureSet, error) {
+	return c.FeaturesContext(context.Background())
+}
+
+// FeaturesContext is like Features, but gives up when ctx is done.
+func (c *Device) FeaturesContext(ctx context.Context) (FeatureSet, error) {
+	features, err := c.features(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "Features")
+	}
+	return copyFeatureSet(features), nil
+}
+
+// features returns the device's features, from the cache if possible. The result must not be
+// modified.
+func (c *Device) features(ctx context.Context) (FeatureSet, error) {
+	c.featuresLock.Lock()
+	defer c.featuresLock.Unlock()
+	if c.cachedFeatures != nil {
+		return c.cachedFeatures, nil
+	}
+
+	resp, err := c.getAttribute(ctx, "features")
+	if err != nil {
+		return nil, err
+	}
+	c.cachedFeatures = parseFeatureSet(resp)
+	return c.cachedFeatures, nil
+}
+
+// forgetFeatures clears the cached features. It's called when the device may have disconnected,
+// since it can come back with different ones, e.g. after rebooting into recovery.
+func (c *Device) forgetFeatures() {
+	c.featuresLock.Lock()
+	defer c.featuresLock.Unlock()
+	c.cachedFeatures = nil
+}
+
+// forgetFeaturesAfter calls forgetFeatures if err means the device may have disconnected.
+func (c *Device) forgetFeaturesAfter(err error) {
+	if isDisconnectError(err) {
+		c.forgetFeatures()
+	}
+}
+
+// isDisconnectError returns true if err means that the connection to the server or the device
+// was lost, or the device couldn't be found.
+func isDisconnectError(err error) bool {
+	return HasErrCode(err, DeviceNotFound) ||
+		HasErrCode(err, ConnectionResetError) ||
+		HasErrCode(err, NetworkError) ||
+		HasErrCode(err, ServerNotAvailable)
+}
+
+func copyFeatureSet(features FeatureSet) FeatureSet {
+	result := make(FeatureSet, len(features))
+	for feature, ok := range features {
+		result[feature] = ok
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package adb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drtechco/goadb/internal/errors"
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFeatureSet(t *testing.T) {
+	features := parseFeatureSet("shell_v2,cmd, stat_v2,,sendrecv_v2_zstd\n")
+	assert.True(t, features.Has(FeatureShell2))
+	assert.True(t, features.Has(FeatureStat2))
+	assert.True(t, features.Has(FeatureSendRecv2Zstd))
+	assert.False(t, features.Has(FeatureSendRecv2))
+	assert.Equal(t, "cmd,sendrecv_v2_zstd,shell_v2,stat_v2", features.String())
+
+	assert.Empty(t, parseFeatureSet(""))
+}
+
+func TestHostFeatures(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2,cmd,libusb"},
+	}
+	client := &Adb{s}
+
+	features, err := client.HostFeatures()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host:host-features"}, s.Requests)
+	assert.Equal(t, FeatureSet{FeatureShell2: true, FeatureCmd: true, FeatureLibusb: true}, features)
+}
+
+func TestDeviceFeaturesCached(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2,cmd"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	features, err := client.Features()
+	assert.NoError(t, err)
+	assert.True(t, features.Has(FeatureCmd))
+	// Callers can't modify the cached set.
+	delete(features, FeatureCmd)
+
+	features, err = client.Features()
+	assert.NoError(t, err)
+	assert.True(t, features.Has(FeatureCmd))
+	assert.Equal(t, []string{"host-serial:abc:features"}, s.Requests)
+}
+
+func TestDeviceFeaturesRefetchedAfterDisconnect(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		Messages: []string{
+			"shell_v2,stat_v2",
+			// The device rebooted into recovery.
+			"cmd",
+		},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	features, err := client.Features()
+	assert.NoError(t, err)
+	assert.True(t, features.Has(FeatureShell2))
+
+	s.Errs = []error{nil, nil, errors.Errorf(errors.DeviceNotFound, "device 'abc' not found")}
+	_, err = client.Remount()
+	assert.True(t, HasErrCode(err, DeviceNotFound))
+
+	features, err = client.Features()
+	assert.NoError(t, err)
+	assert.Equal(t, FeatureSet{FeatureCmd: true}, features)
+	assert.Equal(t, []string{
+		"host-serial:abc:features",
+		"host:transport:abc",
+		"host-serial:abc:features",
+	}, s.Requests)
+}
+
+func TestDeviceFeaturesNotForgottenAfterCommandError(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	_, err := client.Features()
+	assert.NoError(t, err)
+
+	// The device rejected the service, so it's still connected.
+	s.Errs = []error{nil, nil, nil, nil, errors.Errorf(errors.AdbError, "closed")}
+	_, err = client.DialService(context.Background(), "tcp:8080")
+	assert.True(t, HasErrCode(err, AdbError))
+
+	features, err := client.Features()
+	assert.NoError(t, err)
+	assert.True(t, features.Has(FeatureShell2))
+	assert.Equal(t, []string{"host-serial:abc:features", "host:transport:abc", "tcp:8080"}, s.Requests)
+}
+
+func TestDeviceFeaturesWithTransportID(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2"},
+	}
+	client := (&Adb{s}).Device(DeviceWithTransportID(3))
+
+	for i := 0; i < 2; i++ {
+		features, err := client.Features()
+		assert.NoError(t, err)
+		assert.True(t, features.Has(FeatureShell2))
+	}
+	assert.Equal(t, []string{"host-transport-id:3:features"}, s.Requests)
+}
@@ -0,0 +1,340 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+/*
+The file operations below run the equivalent shell command on the device. Arguments are quoted, so
+paths may contain spaces and shell metacharacters. If a command fails, the error it prints is
+mapped to a RemoteErrno when possible, so errors.Is matches fs.ErrNotExist, fs.ErrExist and
+fs.ErrPermission like it does for the os package's errors.
+*/
+
+/*
+MkdirAll creates the directory at path on the device, along with any missing parents. Directories
+that are created get the permission bits perm. It does nothing if the directory already exists.
+
+Corresponds to the command:
+
+	adb shell mkdir -p -m <perm> <path>
+*/
+func (c *Device) MkdirAll(ctx context.Context, path string, perm os.FileMode) error {
+	mode := strconv.FormatUint(uint64(perm.Perm()), 8)
+	_, err := c.runFileCommand(ctx, path, shellCommandLine("mkdir", "-p", "-m", mode, "--", path))
+	return wrapClientError(err, c, "MkdirAll(%s)", path)
+}
+
+/*
+Remove removes the file or empty directory at path on the device. Symlinks are removed, not the
+files they point to.
+
+Corresponds to the command:
+
+	adb shell rmdir <path>   # for directories
+	adb shell rm <path>      # for everything else
+*/
+func (c *Device) Remove(ctx context.Context, path string) error {
+	quoted := shellQuote(path)
+	script := fmt.Sprintf("if [ -d %[1]s ] && [ ! -L %[1]s ]; then rmdir -- %[1]s; else rm -- %[1]s; fi", quoted)
+	_, err := c.runFileCommand(ctx, path, script)
+	return wrapClientError(err, c, "Remove(%s)", path)
+}
+
+/*
+RemoveAll removes path on the device and anything it contains. It returns nil if path doesn't
+exist.
+
+Corresponds to the command:
+
+	adb shell rm -rf <path>
+*/
+func (c *Device) RemoveAll(ctx context.Context, path string) error {
+	_, err := c.runFileCommand(ctx, path, shellCommandLine("rm", "-rf", "--", path))
+	return wrapClientError(err, c, "RemoveAll(%s)", path)
+}
+
+/*
+Rename moves oldpath to newpath on the device, replacing newpath if it's a file. Like mv, if newpath
+is an existing directory, oldpath is moved into it.
+
+Corresponds to the command:
+
+	adb shell mv -f <oldpath> <newpath>
+*/
+func (c *Device) Rename(ctx context.Context, oldpath, newpath string) error {
+	_, err := c.runFileCommand(ctx, oldpath, shellCommandLine("mv", "-f", "--", oldpath, newpath))
+	return wrapClientError(err, c, "Rename(%s, %s)", oldpath, newpath)
+}
+
+/*
+Chmod sets the permission bits of path on the device to mode. If path is a symlink, the file it
+points to is changed.
+
+Corresponds to the command:
+
+	adb shell chmod <mode> <path>
+*/
+func (c *Device) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	bits := strconv.FormatUint(uint64(mode.Perm()), 8)
+	_, err := c.runFileCommand(ctx, path, shellCommandLine("chmod", bits, "--", path))
+	return wrapClientError(err, c, "Chmod(%s)", path)
+}
+
+/*
+Chown sets the numeric owner and group of path on the device. Like os.Chown, a uid or gid of -1
+leaves that value unchanged. Changing the owner usually requires root.
+
+Corresponds to the command:
+
+	adb shell chown <uid>:<gid> <path>
+*/
+func (c *Device) Chown(ctx context.Context, path string, uid, gid int) error {
+	var commandLine string
+	switch {
+	case uid < 0 && gid < 0:
+		return nil
+	case uid < 0:
+		commandLine = shellCommandLine("chgrp", strconv.Itoa(gid), "--", path)
+	case gid < 0:
+		commandLine = shellCommandLine("chown", strconv.Itoa(uid), "--", path)
+	default:
+		commandLine = shellCommandLine("chown", fmt.Sprintf("%d:%d", uid, gid), "--", path)
+	}
+	_, err := c.runFileCommand(ctx, path, commandLine)
+	return wrapClientError(err, c, "Chown(%s)", path)
+}
+
+/*
+Symlink creates newname on the device as a symlink to oldname. It fails if newname already exists.
+
+Corresponds to the command:
+
+	adb shell ln -s <oldname> <newname>
+*/
+func (c *Device) Symlink(ctx context.Context, oldname, newname string) error {
+	_, err := c.runFileCommand(ctx, newname, shellCommandLine("ln", "-s", "--", oldname, newname))
+	return wrapClientError(err, c, "Symlink(%s, %s)", oldname, newname)
+}
+
+/*
+Readlink returns the target of the symlink at name on the device, as stored in the link.
+
+Corresponds to the command:
+
+	adb shell readlink <name>
+*/
+func (c *Device) Readlink(ctx context.Context, name string) (string, error) {
+	output, status, err := c.runWithStatus(ctx, shellCommandLine("readlink", "--", name))
+	if err != nil {
+		return "", wrapClientError(err, c, "Readlink(%s)", name)
+	}
+	target := strings.TrimRight(output, "\r\n")
+	if status == 0 && target != "" {
+		return target, nil
+	}
+	if strings.TrimSpace(output) != "" {
+		return "", wrapClientError(fileCommandError(name, output, status), c, "Readlink(%s)", name)
+	}
+
+	// readlink doesn't say why it failed, so check whether the file exists.
+	if _, err := c.StatContext(ctx, name); err != nil {
+		return "", err
+	}
+	return "", wrapClientError(remoteErrnoError(remoteEINVAL, name), c, "Readlink(%s)", name)
+}
+
+/*
+Truncate changes the size of the file at path on the device. If the file is longer than size, the
+extra data is lost; if it's shorter, it's extended with zero bytes. Like the truncate command, the
+file is created if it doesn't exist.
+
+Corresponds to the command:
+
+	adb shell truncate -s <size> <path>
+*/
+func (c *Device) Truncate(ctx context.Context, path string, size int64) error {
+	_, err := c.runFileCommand(ctx, path, shellCommandLine("truncate", "-s", strconv.FormatInt(size, 10), "--", path))
+	return wrapClientError(err, c, "Truncate(%s)", path)
+}
+
+// setModTime sets the modification time of the existing file at path on the device.
+func (c *Device) setModTime(ctx context.Context, path string, mtime time.Time) error {
+	_, err := c.runFileCommand(ctx, path, shellCommandLine("touch", "-c", "-m", "-d", fmt.Sprintf("@%d", mtime.Unix()), "--", path))
+	return err
+}
+
+// statFollowingLinks is like Stat, but returns the attributes of the file that path links to.
+func (c *Device) statFollowingLinks(ctx context.Context, path string) (*DirEntry, error) {
+	features, err := c.features(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "Stat(%s)", path)
+	}
+	if !features.Has(FeatureStat2) {
+		// The sync protocol can't follow links without STA2, so ask the shell where it points.
+		target, err := c.resolveLink(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return c.StatContext(ctx, target)
+	}
+
+	conn, _, err := c.getSyncConn(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "Stat(%s)", path)
+	}
+	defer conn.Close()
+
+	entry, err := statLinkTarget(conn, path)
+	return entry, wrapClientError(err, c, "Stat(%s)", path)
+}
+
+// resolveLink returns the canonical path of the file that the symlink at path points to.
+func (c *Device) resolveLink(ctx context.Context, path string) (string, error) {
+	output, err := c.runFileCommand(ctx, path, shellCommandLine("readlink", "-f", "--", path))
+	if err != nil {
+		return "", err
+	}
+	target := strings.TrimRight(output, "\r\n")
+	if target == "" || strings.Contains(target, "\n") {
+		return "", errors.Errorf(errors.AdbError, "can't resolve symlink %s: %s", path, strings.TrimSpace(output))
+	}
+	return target, nil
+}
+
+// runFileCommand runs commandLine on the device and returns its stdout and stderr interleaved. If
+// it exits with a non-zero status, the error is mapped from its output, assuming it's about path.
+func (c *Device) runFileCommand(ctx context.Context, path, commandLine string) (string, error) {
+	output, status, err := c.runWithStatus(ctx, commandLine)
+	if err != nil {
+		return "", err
+	}
+	if status != 0 {
+		return output, fileCommandError(path, output, status)
+	}
+	return output, nil
+}
+
+// runWithStatus runs commandLine on the device and returns its stdout and stderr interleaved,
+// and its exit status. The exec service doesn't report the exit status, so the shell prints it
+// after the command's output.
+func (c *Device) runWithStatus(ctx context.Context, commandLine string) (output string, status int, err error) {
+	var buf bytes.Buffer
+	status, err = c.streamWithStatus(ctx, commandLine, &buf)
+	if err != nil {
+		return "", 0, err
+	}
+	return buf.String(), status, nil
+}
+
+// streamWithStatus is like runWithStatus, but writes the output to w as it's received.
+func (c *Device) streamWithStatus(ctx context.Context, commandLine string, w io.Writer) (status int, err error) {
+	conn, err := c.openService(ctx, statusService(commandLine))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	output := &statusTrailerWriter{w: w}
+	if _, err := io.Copy(output, conn); err != nil {
+		return 0, errors.WrapErrorf(err, errors.NetworkError, "error copying command output")
+	}
+	_, status, err = parseExitStatus(string(output.tail))
+	return status, err
+}
+
+// statusTrailerWriter writes the output of a command run by streamWithStatus to w, except for
+// the exit status at the end. Since it can't tell where the output ends until the connection is
+// closed, it holds back the last line while it could still be the status.
+type statusTrailerWriter struct {
+	w    io.Writer
+	tail []byte
+}
+
+// maxStatusLength is the longest exit status that the shell can print.
+const maxStatusLength = len("255")
+
+func (s *statusTrailerWriter) Write(buf []byte) (int, error) {
+	s.tail = append(s.tail, buf...)
+	hold := bytes.LastIndexByte(s.tail, '\n')
+	if hold < 0 || !isExitStatusPrefix(s.tail[hold+1:]) {
+		hold = len(s.tail)
+	}
+	if _, err := s.w.Write(s.tail[:hold]); err != nil {
+		return 0, err
+	}
+	s.tail = append(s.tail[:0], s.tail[hold:]...)
+	return len(buf), nil
+}
+
+// isExitStatusPrefix returns true if b could be the start of an exit status.
+func isExitStatusPrefix(b []byte) bool {
+	if len(b) > maxStatusLength {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusService returns the service that streamWithStatus opens to run commandLine.
+func statusService(commandLine string) string {
+	return "exec:{ " + commandLine + "; } 2>&1; printf '\\n%d' $?"
+}
+
+// parseExitStatus splits the output of a command run by runWithStatus into the command's own
+// output and its exit status.
+func parseExitStatus(result string) (output string, status int, err error) {
+	i := strings.LastIndexByte(result, '\n')
+	if i < 0 {
+		return "", 0, errors.Errorf(errors.ParseError, "missing exit status in command output: %q", result)
+	}
+	status, err = strconv.Atoi(result[i+1:])
+	if err != nil {
+		return "", 0, errors.WrapErrorf(err, errors.ParseError, "invalid exit status in command output: %q", result)
+	}
+	return result[:i], status, nil
+}
+
+// remoteErrnosByMessage are the errnos that fileCommandError looks for in error messages, in a
+// fixed order so that the result doesn't depend on map iteration.
+var remoteErrnosByMessage = sortedRemoteErrnos()
+
+func sortedRemoteErrnos() []RemoteErrno {
+	var errnos []RemoteErrno
+	for errno := range remoteErrnoMessages {
+		errnos = append(errnos, errno)
+	}
+	sort.Slice(errnos, func(i, j int) bool {
+		return errnos[i] < errnos[j]
+	})
+	return errnos
+}
+
+// fileCommandError returns the error for a command about path that exited with status, after
+// printing output. Shell tools print strerror messages, which are matched back to an errno.
+func fileCommandError(path, output string, status int) error {
+	lowerOutput := strings.ToLower(output)
+	for _, errno := range remoteErrnosByMessage {
+		if strings.Contains(lowerOutput, remoteErrnoMessages[errno]) {
+			return remoteErrnoError(errno, path)
+		}
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		return errors.Errorf(errors.AdbError, "%s: command failed with status %d: %s", path, status, output)
+	}
+	return errors.Errorf(errors.AdbError, "%s: command failed with status %d", path, status)
+}
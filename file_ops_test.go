@@ -0,0 +1,150 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"io/fs"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExitStatus(t *testing.T) {
+	output, status, err := parseExitStatus("hello\n\n0")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", output)
+	assert.Equal(t, 0, status)
+
+	output, status, err = parseExitStatus("\n127")
+	assert.NoError(t, err)
+	assert.Equal(t, "", output)
+	assert.Equal(t, 127, status)
+
+	_, _, err = parseExitStatus("killed")
+	assert.True(t, HasErrCode(err, ParseError))
+}
+
+func TestStatusTrailerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &statusTrailerWriter{w: &buf}
+
+	// Output is written as it's received, except for what could be the exit status.
+	for _, chunk := range []string{"line 1\nline", " 2\n12", "3\n", "no newline", "\n", "1"} {
+		_, err := w.Write([]byte(chunk))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "line 1\nline 2\n123\nno newline", buf.String())
+	assert.Equal(t, "\n1", string(w.tail))
+
+	_, status, err := parseExitStatus(string(w.tail))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+
+	// A long line of digits can't be the status, so it isn't held back.
+	buf.Reset()
+	w = &statusTrailerWriter{w: &buf}
+	w.Write([]byte("\n12345678"))
+	assert.Equal(t, "\n12345678", buf.String())
+	assert.Empty(t, w.tail)
+}
+
+func TestFileCommandError(t *testing.T) {
+	err := fileCommandError("/sdcard/x", "rm: /sdcard/x: No such file or directory\n", 1)
+	assert.True(t, HasErrCode(err, FileNoExistError))
+	assert.True(t, stderrors.Is(err, fs.ErrNotExist))
+
+	err = fileCommandError("/data/x", "mkdir: '/data/x': Permission denied\n", 1)
+	assert.True(t, stderrors.Is(err, fs.ErrPermission))
+
+	err = fileCommandError("/sdcard/x", "ln: /sdcard/x: File exists\n", 1)
+	assert.True(t, stderrors.Is(err, fs.ErrExist))
+
+	err = fileCommandError("/sdcard/x", "rmdir: '/sdcard/x': Directory not empty\n", 1)
+	assert.Equal(t, RemoteErrno(39), errorCause(err))
+
+	err = fileCommandError("/sdcard/x", "something odd\n", 2)
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.Equal(t, "AdbError: /sdcard/x: command failed with status 2: something odd", err.Error())
+}
+
+// errorCause returns the RemoteErrno in err's chain, or 0.
+func errorCause(err error) RemoteErrno {
+	var errno RemoteErrno
+	stderrors.As(err, &errno)
+	return errno
+}
+
+func TestMkdirAll(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	assert.NoError(t, client.MkdirAll(context.Background(), "/sdcard/a b", 0755))
+	assert.Equal(t, `exec:{ mkdir -p -m 755 -- '/sdcard/a b'; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestRemoveFails(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"rm: /sdcard/it's: No such file or directory\n", "\n1"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	err := client.Remove(context.Background(), "/sdcard/it's")
+	assert.True(t, stderrors.Is(err, fs.ErrNotExist))
+	assert.Equal(t, `exec:{ if [ -d '/sdcard/it'\''s' ] && [ ! -L '/sdcard/it'\''s' ]; then rmdir -- '/sdcard/it'\''s'; `+
+		`else rm -- '/sdcard/it'\''s'; fi; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestChown(t *testing.T) {
+	for _, test := range []struct {
+		uid, gid int
+		command  string
+	}{
+		{1000, 2000, "chown 1000:2000 -- /sdcard/f"},
+		{1000, -1, "chown 1000 -- /sdcard/f"},
+		{-1, 2000, "chgrp 2000 -- /sdcard/f"},
+	} {
+		s := &MockServer{
+			Status:   wire.StatusSuccess,
+			Messages: []string{"\n0"},
+		}
+		client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+		assert.NoError(t, client.Chown(context.Background(), "/sdcard/f", test.uid, test.gid))
+		assert.Equal(t, "exec:{ "+test.command+"; } 2>&1; printf '\\n%d' $?", s.Requests[1])
+	}
+
+	s := &MockServer{}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	assert.NoError(t, client.Chown(context.Background(), "/sdcard/f", -1, -1))
+	assert.Empty(t, s.Requests)
+}
+
+func TestSetModTime(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	assert.NoError(t, client.setModTime(context.Background(), "/sdcard/f", someTime))
+	assert.Equal(t, "exec:{ touch -c -m -d @1430640488 -- /sdcard/f; } 2>&1; printf '\\n%d' $?", s.Requests[1])
+}
+
+func TestReadlink(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"/data/local/tmp\n", "\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	target, err := client.Readlink(context.Background(), "/sdcard/link")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/local/tmp", target)
+	assert.Equal(t, `exec:{ readlink -- /sdcard/link; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
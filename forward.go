@@ -0,0 +1,317 @@
+package adb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+// ForwardProtocol is the type of socket a ForwardSpec refers to.
+type ForwardProtocol string
+
+const (
+	// Address is a TCP port number. On the host side, port 0 picks a free port.
+	ForwardTCP ForwardProtocol = "tcp"
+	// Address is the name of a Unix domain socket in the abstract namespace.
+	ForwardLocalAbstract ForwardProtocol = "localabstract"
+	// Address is the name of a Unix domain socket in /dev/socket.
+	ForwardLocalReserved ForwardProtocol = "localreserved"
+	// Address is the path of a Unix domain socket on the filesystem.
+	ForwardLocalFilesystem ForwardProtocol = "localfilesystem"
+	// Address is the pid of a process to debug. Only valid as a remote spec.
+	ForwardJDWP ForwardProtocol = "jdwp"
+	// Address is the path of a character device. Only valid as a remote spec.
+	ForwardDev ForwardProtocol = "dev"
+)
+
+// ForwardSpec is one end of a port forward, e.g. "tcp:8080" or "localabstract:foo".
+type ForwardSpec struct {
+	Protocol ForwardProtocol
+	Address  string
+}
+
+// TCPForwardSpec returns the ForwardSpec for a TCP port.
+func TCPForwardSpec(port int) ForwardSpec {
+	return ForwardSpec{Protocol: ForwardTCP, Address: strconv.Itoa(port)}
+}
+
+// ParseForwardSpec parses a spec in the format used by adb, e.g. "tcp:8080".
+func ParseForwardSpec(spec string) (ForwardSpec, error) {
+	parsed, err := splitForwardSpec(spec)
+	if err != nil {
+		return ForwardSpec{}, err
+	}
+	if err := parsed.validate(); err != nil {
+		return ForwardSpec{}, err
+	}
+	return parsed, nil
+}
+
+// splitForwardSpec splits spec into its protocol and address, without checking that the protocol
+// is one the library knows about. Servers and devices may report protocols added after this
+// library was written.
+func splitForwardSpec(spec string) (ForwardSpec, error) {
+	split := strings.SplitN(spec, ":", 2)
+	if len(split) != 2 || split[1] == "" {
+		return ForwardSpec{}, errors.Errorf(errors.ParseError, "invalid forward spec: %q", spec)
+	}
+	return ForwardSpec{Protocol: ForwardProtocol(split[0]), Address: split[1]}, nil
+}
+
+// validate checks that s has a known protocol and a valid address for it.
+func (s ForwardSpec) validate() error {
+	switch s.Protocol {
+	case ForwardTCP, ForwardJDWP:
+		if _, err := strconv.ParseUint(s.Address, 10, 32); err != nil {
+			return errors.WrapErrorf(err, errors.ParseError, "invalid %s forward spec: %q", s.Protocol, s)
+		}
+	case ForwardLocalAbstract, ForwardLocalReserved, ForwardLocalFilesystem, ForwardDev:
+		if s.Address == "" {
+			return errors.Errorf(errors.ParseError, "invalid forward spec: %q", s)
+		}
+	default:
+		return errors.Errorf(errors.ParseError, "unknown forward protocol in spec: %q", s)
+	}
+	return nil
+}
+
+func (s ForwardSpec) String() string {
+	return fmt.Sprintf("%s:%s", s.Protocol, s.Address)
+}
+
+// ForwardEntry is a port forward, as reported by the adb server.
+type ForwardEntry struct {
+	// Serial of the device the forward is for.
+	Serial string
+	Local  ForwardSpec
+	Remote ForwardSpec
+}
+
+/*
+Forward forwards connections to local on the host to remote on the device.
+If noRebind is true, fails if local is already forwarded.
+
+It returns the spec the host is listening on, which is local unless it's tcp:0, in which case the
+server picks a free port.
+
+Corresponds to the command:
+
+	adb forward [--no-rebind] <local> <remote>
+*/
+func (c *Device) Forward(local, remote ForwardSpec, noRebind bool) (ForwardSpec, error) {
+	return c.ForwardContext(context.Background(), local, remote, noRebind)
+}
+
+// ForwardContext is like Forward, but gives up when ctx is done.
+func (c *Device) ForwardContext(ctx context.Context, local, remote ForwardSpec, noRebind bool) (ForwardSpec, error) {
+	if err := validateForwardSpecs(local, remote); err != nil {
+		return ForwardSpec{}, wrapClientError(err, c, "Forward(%s, %s)", local, remote)
+	}
+
+	cmd := "forward"
+	if noRebind {
+		cmd = "forward:norebind"
+	}
+	req := fmt.Sprintf("%s:%s:%s;%s", c.descriptor.getHostPrefix(), cmd, local, remote)
+
+	conn, err := c.server.Dial(ctx)
+	if err != nil {
+		return ForwardSpec{}, wrapClientError(err, c, "Forward(%s, %s)", local, remote)
+	}
+	defer conn.Close()
+
+	listening, err := sendCreateForwardRequest(conn, req, local)
+	return listening, wrapClientError(err, c, "Forward(%s, %s)", local, remote)
+}
+
+/*
+ListForwards returns the port forwards set up for this device.
+
+Corresponds to the command:
+
+	adb forward --list
+*/
+func (c *Device) ListForwards() ([]ForwardEntry, error) {
+	return c.ListForwardsContext(context.Background())
+}
+
+// ListForwardsContext is like ListForwards, but gives up when ctx is done.
+func (c *Device) ListForwardsContext(ctx context.Context) ([]ForwardEntry, error) {
+	// The server lists forwards for all devices, regardless of the transport requested.
+	serial, err := c.SerialContext(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListForwards")
+	}
+
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:list-forward")
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListForwards")
+	}
+
+	forwards, err := parseForwardList(string(resp))
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListForwards")
+	}
+
+	var deviceForwards []ForwardEntry
+	for _, forward := range forwards {
+		if forward.Serial == serial {
+			deviceForwards = append(deviceForwards, forward)
+		}
+	}
+	return deviceForwards, nil
+}
+
+/*
+RemoveForward removes the port forward from local.
+
+Corresponds to the command:
+
+	adb forward --remove <local>
+*/
+func (c *Device) RemoveForward(local ForwardSpec) error {
+	return c.RemoveForwardContext(context.Background(), local)
+}
+
+// RemoveForwardContext is like RemoveForward, but gives up when ctx is done.
+func (c *Device) RemoveForwardContext(ctx context.Context, local ForwardSpec) error {
+	req := fmt.Sprintf("%s:killforward:%s", c.descriptor.getHostPrefix(), local)
+	err := roundTripForwardRequest(ctx, c.server, req)
+	return wrapClientError(err, c, "RemoveForward(%s)", local)
+}
+
+/*
+ListForwards returns the port forwards set up for all devices.
+
+Corresponds to the command:
+
+	adb forward --list
+*/
+func (c *Adb) ListForwards() ([]ForwardEntry, error) {
+	return c.ListForwardsContext(context.Background())
+}
+
+// ListForwardsContext is like ListForwards, but gives up when ctx is done.
+func (c *Adb) ListForwardsContext(ctx context.Context) ([]ForwardEntry, error) {
+	resp, err := roundTripSingleResponse(ctx, c.server, "host:list-forward")
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListForwards")
+	}
+
+	forwards, err := parseForwardList(string(resp))
+	return forwards, wrapClientError(err, c, "ListForwards")
+}
+
+/*
+RemoveAllForwards removes all port forwards, for all devices.
+
+Corresponds to the command:
+
+	adb forward --remove-all
+*/
+func (c *Adb) RemoveAllForwards() error {
+	return c.RemoveAllForwardsContext(context.Background())
+}
+
+// RemoveAllForwardsContext is like RemoveAllForwards, but gives up when ctx is done.
+func (c *Adb) RemoveAllForwardsContext(ctx context.Context) error {
+	err := roundTripForwardRequest(ctx, c.server, "host:killforward-all")
+	return wrapClientError(err, c, "RemoveAllForwards")
+}
+
+// roundTripForwardRequest sends a request that creates or removes forwards on a new connection.
+func roundTripForwardRequest(ctx context.Context, s server, req string) error {
+	conn, err := s.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return sendForwardRequest(conn, req)
+}
+
+// sendForwardRequest sends a request that creates or removes forwards, and reads the response.
+// These requests get two statuses: the first acknowledges the request, the second reports whether
+// the forward was actually changed.
+func sendForwardRequest(conn *wire.Conn, req string) error {
+	if err := wire.SendMessageString(conn, req); err != nil {
+		return err
+	}
+	if _, err := conn.ReadStatus(req); err != nil {
+		return err
+	}
+	_, err := conn.ReadStatus(req)
+	return err
+}
+
+// validateForwardSpecs checks the specs of a forward or reverse before it's created.
+func validateForwardSpecs(specs ...ForwardSpec) error {
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendCreateForwardRequest sends a request that creates a forward listening on spec, and returns
+// the spec it's actually listening on. For tcp:0, the port that was picked is sent after the
+// second status.
+func sendCreateForwardRequest(conn *wire.Conn, req string, spec ForwardSpec) (ForwardSpec, error) {
+	if err := sendForwardRequest(conn, req); err != nil {
+		return ForwardSpec{}, err
+	}
+	if spec.Protocol != ForwardTCP || spec.Address != "0" {
+		return spec, nil
+	}
+
+	resp, err := conn.ReadMessage()
+	if err != nil {
+		return ForwardSpec{}, err
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(resp)))
+	if err != nil {
+		return ForwardSpec{}, errors.WrapErrorf(err, errors.ParseError, "invalid port in response: %q", resp)
+	}
+	return TCPForwardSpec(port), nil
+}
+
+// parseForwardList parses the response to a list-forward request, which contains one
+// "<serial> <local> <remote>" line per forward. Specs with protocols the library doesn't know about
+// are kept as they are.
+func parseForwardList(list string) ([]ForwardEntry, error) {
+	forwards := []ForwardEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(list))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, errors.Errorf(errors.ParseError,
+				"malformed forward line, expected 3 fields but found %d", len(fields))
+		}
+
+		local, err := splitForwardSpec(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remote, err := splitForwardSpec(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, ForwardEntry{
+			Serial: fields[0],
+			Local:  local,
+			Remote: remote,
+		})
+	}
+
+	return forwards, nil
+}
@@ -0,0 +1,161 @@
+package adb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForwardSpec(t *testing.T) {
+	spec, err := ParseForwardSpec("tcp:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, TCPForwardSpec(8080), spec)
+
+	spec, err = ParseForwardSpec("localabstract:foo:bar")
+	assert.NoError(t, err)
+	assert.Equal(t, ForwardSpec{ForwardLocalAbstract, "foo:bar"}, spec)
+	assert.Equal(t, "localabstract:foo:bar", spec.String())
+
+	spec, err = ParseForwardSpec("jdwp:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, ForwardSpec{ForwardJDWP, "1234"}, spec)
+}
+
+func TestParseForwardSpecInvalid(t *testing.T) {
+	for _, spec := range []string{"", "tcp", "tcp:", "tcp:foo", "jdwp:-1", "udp:53"} {
+		_, err := ParseForwardSpec(spec)
+		assert.True(t, HasErrCode(err, ParseError), "spec %q", spec)
+	}
+}
+
+func TestParseForwardList(t *testing.T) {
+	forwards, err := parseForwardList(`abc tcp:6000 tcp:7000
+def tcp:6001 localabstract:foo
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, []ForwardEntry{
+		{Serial: "abc", Local: TCPForwardSpec(6000), Remote: TCPForwardSpec(7000)},
+		{Serial: "def", Local: TCPForwardSpec(6001), Remote: ForwardSpec{ForwardLocalAbstract, "foo"}},
+	}, forwards)
+
+	forwards, err = parseForwardList("")
+	assert.NoError(t, err)
+	assert.Empty(t, forwards)
+
+	_, err = parseForwardList("abc tcp:6000\n")
+	assert.True(t, HasErrCode(err, ParseError))
+}
+
+func TestParseForwardListUnknownProtocol(t *testing.T) {
+	forwards, err := parseForwardList("abc tcp:6000 vsock:3:5000\nabc acceptfd:4 tcp:7000\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []ForwardEntry{
+		{Serial: "abc", Local: TCPForwardSpec(6000), Remote: ForwardSpec{"vsock", "3:5000"}},
+		{Serial: "abc", Local: ForwardSpec{"acceptfd", "4"}, Remote: TCPForwardSpec(7000)},
+	}, forwards)
+}
+
+func TestForward(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	local, err := client.Forward(TCPForwardSpec(6000), ForwardSpec{ForwardLocalAbstract, "foo"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, TCPForwardSpec(6000), local)
+	assert.Equal(t, []string{"host-serial:abc:forward:tcp:6000;localabstract:foo"}, s.Requests)
+	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "ReadStatus", "Close", "Close"}, s.Trace)
+
+	s.Requests = nil
+	_, err = client.Forward(TCPForwardSpec(6000), TCPForwardSpec(7000), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host-serial:abc:forward:norebind:tcp:6000;tcp:7000"}, s.Requests)
+}
+
+func TestForwardPickedPort(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"41233"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	local, err := client.Forward(TCPForwardSpec(0), TCPForwardSpec(7000), false)
+	assert.NoError(t, err)
+	assert.Equal(t, TCPForwardSpec(41233), local)
+	assert.Equal(t, []string{"host-serial:abc:forward:tcp:0;tcp:7000"}, s.Requests)
+}
+
+func TestForwardInvalidSpec(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	_, err := client.Forward(ForwardSpec{"vsock", "3:5000"}, TCPForwardSpec(7000), false)
+	assert.True(t, HasErrCode(err, ParseError))
+	assert.Empty(t, s.Requests)
+}
+
+func TestListForwards(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		Messages: []string{
+			"abc",
+			"abc tcp:6000 tcp:7000\ndef tcp:6001 tcp:7001\n",
+		},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	forwards, err := client.ListForwards()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host-serial:abc:get-serialno", "host:list-forward"}, s.Requests)
+	assert.Equal(t, []ForwardEntry{
+		{Serial: "abc", Local: TCPForwardSpec(6000), Remote: TCPForwardSpec(7000)},
+	}, forwards)
+}
+
+func TestRemoveForward(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(AnyUsbDevice())
+
+	err := client.RemoveForward(TCPForwardSpec(6000))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host-usb:killforward:tcp:6000"}, s.Requests)
+}
+
+func TestRemoveAllForwards(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := &Adb{s}
+
+	err := client.RemoveAllForwards()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host:killforward-all"}, s.Requests)
+}
+
+func TestListAllForwardsManyForwards(t *testing.T) {
+	var lines []string
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("emulator-%d tcp:%d tcp:%d\n", 5554+2*(i%16), 10000+i, 8000+i))
+	}
+	s, requests := newResponseServer(strings.Join(lines, ""))
+	client := &Adb{s}
+
+	forwards, err := client.ListForwards()
+	require.NoError(t, err)
+	assert.Equal(t, "host:list-forward", <-requests)
+	require.Len(t, forwards, 300)
+	assert.Equal(t, ForwardEntry{
+		Serial: "emulator-5576",
+		Local:  TCPForwardSpec(10299),
+		Remote: TCPForwardSpec(8299),
+	}, forwards[299])
+}
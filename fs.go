@@ -0,0 +1,258 @@
+package adb
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+)
+
+/*
+FS returns a file system that reads the files under root on the device, so it can be used with
+fs.WalkDir, fs.Glob, http.FS and the like. root should be an absolute path.
+
+Symlinks are followed when opening or stating a file, but are reported as symlinks by ReadDir.
+Each operation opens its own connection to the device, so the file system is safe for concurrent
+use. Errors are *fs.PathErrors, and match fs.ErrNotExist and fs.ErrPermission when appropriate.
+
+The returned value implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS. The Sys method of the
+fs.FileInfos it returns gives the *DirEntry reported by the device.
+*/
+func (c *Device) FS(root string) fs.FS {
+	return &deviceFS{device: c, root: root}
+}
+
+type deviceFS struct {
+	device *Device
+	root   string
+}
+
+var (
+	_ fs.StatFS     = &deviceFS{}
+	_ fs.ReadDirFS  = &deviceFS{}
+	_ fs.ReadFileFS = &deviceFS{}
+)
+
+func (fsys *deviceFS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &deviceDir{fsys: fsys, name: name, info: info}, nil
+	}
+	return &deviceFile{fsys: fsys, name: name, info: info}, nil
+}
+
+func (fsys *deviceFS) Stat(name string) (fs.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (fsys *deviceFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	remotePath, err := fsys.remotePath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.device.ListDirEntries(remotePath)
+	if err != nil {
+		return nil, fsPathError("readdir", name, err)
+	}
+	all, err := entries.ReadAll()
+	if err != nil {
+		return nil, fsPathError("readdir", name, err)
+	}
+
+	result := make([]fs.DirEntry, 0, len(all))
+	for _, entry := range all {
+		if entry.Name != "." && entry.Name != ".." {
+			result = append(result, dirEntryInfo{entry: entry, name: entry.Name})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+func (fsys *deviceFS) ReadFile(name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+// remotePath returns the path on the device of the file called name in fsys.
+func (fsys *deviceFS) remotePath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(fsys.root, name), nil
+}
+
+// stat returns the attributes of the file called name, following symlinks.
+func (fsys *deviceFS) stat(op, name string) (dirEntryInfo, error) {
+	remotePath, err := fsys.remotePath(op, name)
+	if err != nil {
+		return dirEntryInfo{}, err
+	}
+	entry, err := fsys.device.Stat(remotePath)
+	if err == nil && entry.Mode&fs.ModeSymlink != 0 {
+		entry, err = fsys.device.statFollowingLinks(context.Background(), remotePath)
+	}
+	if err != nil {
+		return dirEntryInfo{}, fsPathError(op, name, err)
+	}
+	return dirEntryInfo{entry: entry, name: path.Base(name)}, nil
+}
+
+// fsPathError returns err as the *fs.PathError that fs.FS methods must return.
+func fsPathError(op, name string, err error) error {
+	if HasErrCode(err, FileNoExistError) {
+		// Files that don't exist aren't always reported with an errno.
+		if !stderrors.As(err, new(RemoteErrno)) {
+			err = fs.ErrNotExist
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// dirEntryInfo adapts a DirEntry to fs.FileInfo and fs.DirEntry.
+type dirEntryInfo struct {
+	entry *DirEntry
+	// The name to report, since stat results don't have one.
+	name string
+}
+
+var (
+	_ fs.FileInfo = dirEntryInfo{}
+	_ fs.DirEntry = dirEntryInfo{}
+)
+
+func (i dirEntryInfo) Name() string               { return i.name }
+func (i dirEntryInfo) Size() int64                { return i.entry.Size }
+func (i dirEntryInfo) Mode() fs.FileMode          { return i.entry.Mode }
+func (i dirEntryInfo) ModTime() time.Time         { return i.entry.ModifiedAt }
+func (i dirEntryInfo) IsDir() bool                { return i.entry.Mode.IsDir() }
+func (i dirEntryInfo) Sys() interface{}           { return i.entry }
+func (i dirEntryInfo) Type() fs.FileMode          { return i.entry.Mode.Type() }
+func (i dirEntryInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i dirEntryInfo) String() string             { return fs.FormatDirEntry(i) }
+
+// deviceFile is a regular file opened from a deviceFS. Its contents aren't requested until the
+// first Read.
+type deviceFile struct {
+	fsys   *deviceFS
+	name   string
+	info   dirEntryInfo
+	reader io.ReadCloser
+	closed bool
+}
+
+func (f *deviceFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.info, nil
+}
+
+func (f *deviceFile) Read(buf []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.reader == nil {
+		remotePath, _ := f.fsys.remotePath("read", f.name)
+		reader, err := f.fsys.device.OpenRead(remotePath)
+		if err != nil {
+			return 0, fsPathError("read", f.name, err)
+		}
+		f.reader = reader
+	}
+
+	n, err := f.reader.Read(buf)
+	if err != nil && err != io.EOF {
+		err = fsPathError("read", f.name, err)
+	}
+	return n, err
+}
+
+func (f *deviceFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+// deviceDir is a directory opened from a deviceFS. Its entries aren't listed until the first
+// ReadDir.
+type deviceDir struct {
+	fsys   *deviceFS
+	name   string
+	info   dirEntryInfo
+	closed bool
+
+	// Nil until the entries are listed.
+	entries []fs.DirEntry
+	offset  int
+}
+
+var _ fs.ReadDirFile = &deviceDir{}
+
+func (d *deviceDir) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+	return d.info, nil
+}
+
+func (d *deviceDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: remoteEISDIR}
+}
+
+func (d *deviceDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if d.entries == nil {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = append([]fs.DirEntry{}, entries...)
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (d *deviceDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
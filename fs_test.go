@@ -0,0 +1,82 @@
+package adb
+
+import (
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/drtechco/goadb/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirEntryInfo(t *testing.T) {
+	entry := &DirEntry{Name: "ignored", Mode: fs.ModeDir | 0755, Size: 4096, ModifiedAt: someTime}
+	info := dirEntryInfo{entry: entry, name: "sdcard"}
+
+	assert.Equal(t, "sdcard", info.Name())
+	assert.True(t, info.IsDir())
+	assert.Equal(t, fs.ModeDir, info.Type())
+	assert.Equal(t, int64(4096), info.Size())
+	assert.Equal(t, someTime, info.ModTime())
+	assert.Equal(t, entry, info.Sys())
+	assert.Equal(t, "d sdcard/", info.String())
+}
+
+func TestFSPathError(t *testing.T) {
+	err := fsPathError("open", "a", errors.Errorf(errors.FileNoExistError, "file doesn't exist"))
+	assert.True(t, stderrors.Is(err, fs.ErrNotExist))
+	assert.Equal(t, "open a: file does not exist", err.Error())
+
+	err = fsPathError("open", "b", remoteErrnoError(remoteEACCES, "/b"))
+	assert.True(t, stderrors.Is(err, fs.ErrPermission))
+	assert.False(t, stderrors.Is(err, fs.ErrNotExist))
+
+	err = fsPathError("open", "c", remoteErrnoError(remoteENOENT, "/c"))
+	assert.True(t, stderrors.Is(err, fs.ErrNotExist))
+	var errno RemoteErrno
+	assert.True(t, stderrors.As(err, &errno))
+}
+
+func TestFSInvalidPath(t *testing.T) {
+	fsys := (&Adb{&MockServer{}}).Device(AnyDevice()).FS("/sdcard")
+
+	for _, name := range []string{"/abs", "../up", "a/", ""} {
+		_, err := fs.Stat(fsys, name)
+		assert.True(t, stderrors.Is(err, fs.ErrInvalid), name)
+	}
+}
+
+func TestDeviceDirReadDir(t *testing.T) {
+	var entries []fs.DirEntry
+	for _, name := range []string{"a", "b", "c"} {
+		entries = append(entries, dirEntryInfo{entry: &DirEntry{}, name: name})
+	}
+	dir := &deviceDir{name: ".", entries: entries}
+
+	batch, err := dir.ReadDir(2)
+	assert.NoError(t, err)
+	assert.Len(t, batch, 2)
+	batch, err = dir.ReadDir(2)
+	assert.NoError(t, err)
+	require.Len(t, batch, 1)
+	assert.Equal(t, "c", batch[0].Name())
+	_, err = dir.ReadDir(2)
+	assert.Equal(t, io.EOF, err)
+
+	batch, err = dir.ReadDir(-1)
+	assert.NoError(t, err)
+	assert.Empty(t, batch)
+
+	assert.NoError(t, dir.Close())
+	_, err = dir.ReadDir(-1)
+	assert.True(t, stderrors.Is(err, fs.ErrClosed))
+}
+
+func TestRemoteErrnoIs(t *testing.T) {
+	assert.True(t, stderrors.Is(remoteENOENT, fs.ErrNotExist))
+	assert.True(t, stderrors.Is(remoteEEXIST, fs.ErrExist))
+	assert.True(t, stderrors.Is(remoteEPERM, fs.ErrPermission))
+	assert.False(t, stderrors.Is(remoteEISDIR, fs.ErrNotExist))
+}
@@ -1,12 +1,13 @@
-
 module github.com/zach-klippenstein/goadb
 
 go 1.23.3
 
-
 require (
 	github.com/alecthomas/kingpin/v2 v2.4.0
+	github.com/andybalholm/brotli v1.2.0
 	github.com/cheggaaa/pb v1.0.29
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.22
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/sys v0.28.0
 )
@@ -0,0 +1,312 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// Directory old devices have APKs pushed to before installing them.
+const legacyInstallDir = "/data/local/tmp"
+
+// How long to spend removing an APK pushed to legacyInstallDir, after the install has finished.
+const legacyCleanupTimeout = 10 * time.Second
+
+var (
+	installFailureRegex = regexp.MustCompile(`Failure \[([^\]:\s]+)(?::\s*([^\]]*))?\]`)
+	installSessionRegex = regexp.MustCompile(`\[(\d+)\]`)
+)
+
+// InstallOptions are the flags passed to the package manager when installing a package.
+type InstallOptions struct {
+	// Replace an existing installation of the package, keeping its data (-r).
+	Replace bool
+	// Allow installing a lower version code than the one already installed (-d).
+	AllowDowngrade bool
+	// Grant all runtime permissions listed in the manifest (-g).
+	GrantPermissions bool
+	// Allow installing packages marked as testOnly (-t).
+	AllowTestPackages bool
+
+	// User to install the package for, e.g. "all", "current" or a user ID (--user).
+	// If empty, the package manager's default is used.
+	User string
+	// ABI to install native libraries for, e.g. "arm64-v8a" (--abi).
+	// If empty, the device's primary ABI is used.
+	ABI string
+}
+
+func (o InstallOptions) args() []string {
+	var args []string
+	if o.Replace {
+		args = append(args, "-r")
+	}
+	if o.AllowDowngrade {
+		args = append(args, "-d")
+	}
+	if o.GrantPermissions {
+		args = append(args, "-g")
+	}
+	if o.AllowTestPackages {
+		args = append(args, "-t")
+	}
+	if o.User != "" {
+		args = append(args, "--user", o.User)
+	}
+	if o.ABI != "" {
+		args = append(args, "--abi", o.ABI)
+	}
+	return args
+}
+
+// APKFile is one of the APKs of a split package installed with InstallMultiple.
+type APKFile struct {
+	// Name of the APK, e.g. "base.apk". Must be unique within the package.
+	Name string
+	// Contents of the APK. Exactly Size bytes are read.
+	Reader io.Reader
+	Size   int64
+}
+
+/*
+InstallError is the cause of an error returned when the package manager reports a failure.
+Use errors.As to get it from the returned error.
+*/
+type InstallError struct {
+	// Reason is the failure code reported by the package manager, e.g.
+	// INSTALL_FAILED_VERSION_DOWNGRADE. Empty if the output didn't contain one.
+	Reason string
+	// Message is the description following the reason, if any.
+	Message string
+	// Output is the full output of the package manager.
+	Output string
+}
+
+func (e *InstallError) Error() string {
+	switch {
+	case e.Reason == "":
+		return fmt.Sprintf("package manager failed: %s", strings.TrimSpace(e.Output))
+	case e.Message == "":
+		return fmt.Sprintf("package manager failed: %s", e.Reason)
+	default:
+		return fmt.Sprintf("package manager failed: %s: %s", e.Reason, e.Message)
+	}
+}
+
+/*
+Install installs the APK read from apk, which must be exactly size bytes long.
+
+The APK is streamed directly to the package manager. Devices too old to support that have the APK
+pushed to /data/local/tmp first, and removed after installing.
+
+If the package manager reports a failure, the returned error has an *InstallError cause.
+
+Corresponds to the command:
+
+	adb install [-r] [-d] [-g] [-t] [--user <user>] [--abi <abi>] <file>
+*/
+func (c *Device) Install(ctx context.Context, apk io.Reader, size int64, opts InstallOptions) error {
+	pm, legacy, err := c.packageManagerCommand(ctx)
+	if err != nil {
+		return wrapClientError(err, c, "Install")
+	}
+
+	if legacy {
+		err = c.installLegacy(ctx, apk, size, opts)
+		return wrapClientError(err, c, "Install")
+	}
+
+	cmd := shellCommandLine(pm, append([]string{"install", "-S", strconv.FormatInt(size, 10)}, opts.args()...)...)
+	output, err := c.execPackageManager(ctx, cmd, apk, size)
+	if err != nil {
+		return wrapClientError(err, c, "Install")
+	}
+	return wrapClientError(parseInstallOutput(output), c, "Install")
+}
+
+/*
+InstallMultiple installs a package made of several APKs, e.g. a base APK and its splits, in a
+single package manager session. If any step fails, the session is abandoned.
+
+If the package manager reports a failure, the returned error has an *InstallError cause.
+
+Corresponds to the command:
+
+	adb install-multiple [-r] [-d] [-g] [-t] [--user <user>] [--abi <abi>] <files...>
+*/
+func (c *Device) InstallMultiple(ctx context.Context, apks []APKFile, opts InstallOptions) error {
+	if len(apks) == 0 {
+		return wrapClientError(errors.AssertionErrorf("must install at least one APK"), c, "InstallMultiple")
+	}
+
+	pm, _, err := c.packageManagerCommand(ctx)
+	if err != nil {
+		return wrapClientError(err, c, "InstallMultiple")
+	}
+
+	var totalSize int64
+	for _, apk := range apks {
+		totalSize += apk.Size
+	}
+
+	cmd := shellCommandLine(pm, append([]string{"install-create", "-S", strconv.FormatInt(totalSize, 10)}, opts.args()...)...)
+	output, err := c.execPackageManager(ctx, cmd, nil, 0)
+	if err == nil {
+		err = parseInstallOutput(output)
+	}
+	if err != nil {
+		return wrapClientError(err, c, "InstallMultiple")
+	}
+
+	match := installSessionRegex.FindStringSubmatch(output)
+	if match == nil {
+		err = errors.Errorf(errors.ParseError, "couldn't find session ID in install-create output: %q", output)
+		return wrapClientError(err, c, "InstallMultiple")
+	}
+	session := match[1]
+
+	if err = c.writeInstallSession(ctx, pm, session, apks); err == nil {
+		output, err = c.execPackageManager(ctx, shellCommandLine(pm, "install-commit", session), nil, 0)
+		if err == nil {
+			err = parseInstallOutput(output)
+		}
+	}
+	if err != nil {
+		// Best effort, the session will eventually be cleaned up by the package manager anyway.
+		c.execPackageManager(ctx, shellCommandLine(pm, "install-abandon", session), nil, 0)
+		return wrapClientError(err, c, "InstallMultiple")
+	}
+	return nil
+}
+
+/*
+Uninstall removes the package from the device. If keepData is true, the package's data and cache
+directories are kept.
+
+If the package manager reports a failure, the returned error has an *InstallError cause.
+
+Corresponds to the command:
+
+	adb uninstall [-k] <package>
+*/
+func (c *Device) Uninstall(ctx context.Context, pkg string, keepData bool) error {
+	pm, _, err := c.packageManagerCommand(ctx)
+	if err != nil {
+		return wrapClientError(err, c, "Uninstall(%s)", pkg)
+	}
+
+	args := []string{"uninstall"}
+	if keepData {
+		args = append(args, "-k")
+	}
+	args = append(args, pkg)
+
+	output, err := c.execPackageManager(ctx, shellCommandLine(pm, args...), nil, 0)
+	if err != nil {
+		return wrapClientError(err, c, "Uninstall(%s)", pkg)
+	}
+	return wrapClientError(parseInstallOutput(output), c, "Uninstall(%s)", pkg)
+}
+
+// packageManagerCommand returns the command to run the package manager with. Devices that support
+// "cmd" talk to the package manager directly, older ones go through the slower pm script, and
+// don't support streamed installs.
+func (c *Device) packageManagerCommand(ctx context.Context) (pm string, legacy bool, err error) {
+	features, err := c.features(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if features.Has(FeatureCmd) {
+		return "cmd package", false, nil
+	}
+	return "pm", true, nil
+}
+
+func (c *Device) writeInstallSession(ctx context.Context, pm string, session string, apks []APKFile) error {
+	for _, apk := range apks {
+		cmd := shellCommandLine(pm, "install-write", "-S", strconv.FormatInt(apk.Size, 10), session, apk.Name, "-")
+		output, err := c.execPackageManager(ctx, cmd, apk.Reader, apk.Size)
+		if err != nil {
+			return err
+		}
+		if err = parseInstallOutput(output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installLegacy pushes the APK to the device, installs it from there, then removes it.
+func (c *Device) installLegacy(ctx context.Context, apk io.Reader, size int64, opts InstallOptions) error {
+	path := fmt.Sprintf("%s/goadb-%d.apk", legacyInstallDir, time.Now().UnixNano())
+
+	writer, err := c.OpenWriteContext(ctx, path, 0644, MtimeOfClose)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(writer, apk, size); err != nil {
+		writer.Close()
+		return errors.WrapErrorf(err, errors.NetworkError, "error pushing APK to %s", path)
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	defer c.removeLegacyAPK(ctx, path)
+
+	output, err := c.RunCommandContext(ctx, "pm", append(append([]string{"install"}, opts.args()...), path)...)
+	if err != nil {
+		return err
+	}
+	return parseInstallOutput(output)
+}
+
+// removeLegacyAPK removes an APK pushed by installLegacy. Installs usually fail because ctx was
+// cancelled or timed out, so the APK is removed even if ctx is done, as long as it doesn't take
+// longer than legacyCleanupTimeout.
+func (c *Device) removeLegacyAPK(ctx context.Context, path string) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), legacyCleanupTimeout)
+	defer cancel()
+	c.RunCommandContext(cleanupCtx, "rm", "-f", path)
+}
+
+// execPackageManager runs cmd with the exec service, writes size bytes from stdin to it if stdin
+// isn't nil, and returns its output.
+func (c *Device) execPackageManager(ctx context.Context, cmd string, stdin io.Reader, size int64) (string, error) {
+	conn, err := c.openService(ctx, "exec:"+cmd)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if stdin != nil {
+		if _, err = io.CopyN(conn, stdin, size); err != nil {
+			return "", errors.WrapErrorf(err, errors.NetworkError, "error writing %d bytes to %s", size, cmd)
+		}
+	}
+
+	output, err := conn.ReadUntilEof()
+	return string(output), err
+}
+
+// parseInstallOutput returns nil if the package manager reported success, else an error with an
+// *InstallError cause.
+func parseInstallOutput(output string) error {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "Success") {
+			return nil
+		}
+	}
+
+	installErr := &InstallError{Output: output}
+	if match := installFailureRegex.FindStringSubmatch(output); match != nil {
+		installErr.Reason = match[1]
+		installErr.Message = strings.TrimSpace(match[2])
+	}
+	return errors.WrapErrorf(installErr, errors.AdbError, "%s", installErr.Error())
+}
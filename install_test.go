@@ -0,0 +1,125 @@
+package adb
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstall(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2,cmd,stat_v2", "Success\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	err := client.Install(context.Background(), strings.NewReader("apk!"), 4, InstallOptions{
+		Replace:          true,
+		GrantPermissions: true,
+		User:             "current",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"host-serial:abc:features",
+		"host:transport:abc",
+		"exec:cmd package install -S 4 -r -g --user current",
+		"apk!",
+	}, s.Requests)
+}
+
+func TestInstallFailure(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		Messages: []string{
+			"cmd",
+			"Failure [INSTALL_FAILED_VERSION_DOWNGRADE: Downgrade detected: Update version code 1 is older than current 2]\n",
+		},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	err := client.Install(context.Background(), strings.NewReader("apk!"), 4, InstallOptions{})
+	assert.True(t, HasErrCode(err, AdbError))
+
+	var installErr *InstallError
+	if assert.True(t, stderrors.As(err, &installErr)) {
+		assert.Equal(t, "INSTALL_FAILED_VERSION_DOWNGRADE", installErr.Reason)
+		assert.Equal(t, "Downgrade detected: Update version code 1 is older than current 2", installErr.Message)
+	}
+}
+
+func TestInstallMultipleCreateFailure(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"cmd", "Error: java.lang.IllegalArgumentException: Unknown option -x\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	err := client.InstallMultiple(context.Background(), []APKFile{
+		{Name: "base.apk", Reader: strings.NewReader("base"), Size: 4},
+		{Name: "split.apk", Reader: strings.NewReader("split"), Size: 5},
+	}, InstallOptions{})
+
+	var installErr *InstallError
+	if assert.True(t, stderrors.As(err, &installErr)) {
+		assert.Equal(t, "", installErr.Reason)
+	}
+	// No session was created, so there's nothing to write to or abandon.
+	assert.Equal(t, []string{
+		"host-serial:abc:features",
+		"host:transport:abc",
+		"exec:cmd package install-create -S 9",
+	}, s.Requests)
+}
+
+func TestUninstall(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"shell_v2", "Success\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	err := client.Uninstall(context.Background(), "com.example.app", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"host-serial:abc:features",
+		"host:transport:abc",
+		"exec:pm uninstall -k com.example.app",
+	}, s.Requests)
+}
+
+func TestParseInstallOutput(t *testing.T) {
+	assert.NoError(t, parseInstallOutput("Success\n"))
+	assert.NoError(t, parseInstallOutput("Performing Streamed Install\r\nSuccess\r\n"))
+	assert.NoError(t, parseInstallOutput("Success: created install session [1234]\n"))
+
+	err := parseInstallOutput("Failure [DELETE_FAILED_INTERNAL_ERROR]\n")
+	var installErr *InstallError
+	if assert.True(t, stderrors.As(err, &installErr)) {
+		assert.Equal(t, "DELETE_FAILED_INTERNAL_ERROR", installErr.Reason)
+		assert.Equal(t, "", installErr.Message)
+	}
+	assert.EqualError(t, installErr, "package manager failed: DELETE_FAILED_INTERNAL_ERROR")
+
+	err = parseInstallOutput("")
+	assert.True(t, HasErrCode(err, AdbError))
+}
+
+func TestRemoveLegacyAPKAfterCancel(t *testing.T) {
+	requests := make(chan string, 2)
+	s := newTCPServer(t, func(conn net.Conn) {
+		acceptRequests(conn, 2, requests)
+	})
+
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client.removeLegacyAPK(ctx, "/data/local/tmp/goadb-1.apk")
+	assert.Equal(t, "host:transport:abc", <-requests)
+	assert.Equal(t, "shell:rm -f /data/local/tmp/goadb-1.apk", <-requests)
+}
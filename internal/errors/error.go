@@ -151,6 +151,11 @@ func (err *Err) Error() string {
 	return msg
 }
 
+// Unwrap returns the cause of err, so errors.Is and errors.As can see through *Errs.
+func (err *Err) Unwrap() error {
+	return err.Cause
+}
+
 // HasErrCode returns true if err is an *Err and err.Code == code.
 func HasErrCode(err error, code ErrCode) bool {
 	switch err := err.(type) {
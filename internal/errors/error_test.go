@@ -42,3 +42,9 @@ func TestCombineErrors(t *testing.T) {
 	assert.Equal(t, `AdbError: hello
 caused by 2 errors: [lulz ∪ fail]`, ErrorWithCauseChain(err))
 }
+
+func TestUnwrap(t *testing.T) {
+	cause := errors.New("cause")
+	err := WrapErrf(WrapErrorf(cause, NetworkError, "wrapped"), "wrapped again")
+	assert.True(t, errors.Is(err, cause))
+}
@@ -0,0 +1,136 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReverseEntry is a reverse port forward, as reported by the device.
+type ReverseEntry struct {
+	// Remote is the spec the device listens on.
+	Remote ForwardSpec
+	// Local is the spec on the host that connections are forwarded to.
+	Local ForwardSpec
+}
+
+/*
+Reverse forwards connections to remote on the device to local on the host.
+If noRebind is true, fails if remote is already forwarded.
+
+It returns the spec the device is listening on, which is remote unless it's tcp:0, in which case
+the device picks a free port.
+
+Corresponds to the command:
+
+	adb reverse [--no-rebind] <remote> <local>
+*/
+func (c *Device) Reverse(remote, local ForwardSpec, noRebind bool) (ForwardSpec, error) {
+	return c.ReverseContext(context.Background(), remote, local, noRebind)
+}
+
+// ReverseContext is like Reverse, but gives up when ctx is done.
+func (c *Device) ReverseContext(ctx context.Context, remote, local ForwardSpec, noRebind bool) (ForwardSpec, error) {
+	if err := validateForwardSpecs(remote, local); err != nil {
+		return ForwardSpec{}, wrapClientError(err, c, "Reverse(%s, %s)", remote, local)
+	}
+
+	cmd := "forward"
+	if noRebind {
+		cmd = "forward:norebind"
+	}
+	req := fmt.Sprintf("reverse:%s:%s;%s", cmd, remote, local)
+
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return ForwardSpec{}, wrapClientError(err, c, "Reverse(%s, %s)", remote, local)
+	}
+	defer conn.Close()
+
+	listening, err := sendCreateForwardRequest(conn, req, remote)
+	return listening, wrapClientError(err, c, "Reverse(%s, %s)", remote, local)
+}
+
+/*
+ListReverses returns the reverse port forwards set up on the device.
+
+Corresponds to the command:
+
+	adb reverse --list
+*/
+func (c *Device) ListReverses() ([]ReverseEntry, error) {
+	return c.ListReversesContext(context.Background())
+}
+
+// ListReversesContext is like ListReverses, but gives up when ctx is done.
+func (c *Device) ListReversesContext(ctx context.Context) ([]ReverseEntry, error) {
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListReverses")
+	}
+	defer conn.Close()
+
+	resp, err := conn.RoundTripSingleResponse([]byte("reverse:list-forward"))
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListReverses")
+	}
+
+	// The device uses the same format as the server, with its own name for the transport in
+	// place of the serial. The listening side comes first.
+	forwards, err := parseForwardList(string(resp))
+	if err != nil {
+		return nil, wrapClientError(err, c, "ListReverses")
+	}
+
+	reverses := make([]ReverseEntry, len(forwards))
+	for i, forward := range forwards {
+		reverses[i] = ReverseEntry{
+			Remote: forward.Local,
+			Local:  forward.Remote,
+		}
+	}
+	return reverses, nil
+}
+
+/*
+RemoveReverse removes the reverse port forward from remote.
+
+Corresponds to the command:
+
+	adb reverse --remove <remote>
+*/
+func (c *Device) RemoveReverse(remote ForwardSpec) error {
+	return c.RemoveReverseContext(context.Background(), remote)
+}
+
+// RemoveReverseContext is like RemoveReverse, but gives up when ctx is done.
+func (c *Device) RemoveReverseContext(ctx context.Context, remote ForwardSpec) error {
+	err := c.roundTripReverseRequest(ctx, fmt.Sprintf("reverse:killforward:%s", remote))
+	return wrapClientError(err, c, "RemoveReverse(%s)", remote)
+}
+
+/*
+RemoveAllReverses removes all reverse port forwards from the device.
+
+Corresponds to the command:
+
+	adb reverse --remove-all
+*/
+func (c *Device) RemoveAllReverses() error {
+	return c.RemoveAllReversesContext(context.Background())
+}
+
+// RemoveAllReversesContext is like RemoveAllReverses, but gives up when ctx is done.
+func (c *Device) RemoveAllReversesContext(ctx context.Context) error {
+	err := c.roundTripReverseRequest(ctx, "reverse:killforward-all")
+	return wrapClientError(err, c, "RemoveAllReverses")
+}
+
+func (c *Device) roundTripReverseRequest(ctx context.Context, req string) error {
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return sendForwardRequest(conn, req)
+}
@@ -0,0 +1,70 @@
+package adb
+
+import (
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverse(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	remote, err := client.Reverse(ForwardSpec{ForwardLocalAbstract, "foo"}, TCPForwardSpec(6000), false)
+	assert.NoError(t, err)
+	assert.Equal(t, ForwardSpec{ForwardLocalAbstract, "foo"}, remote)
+	assert.Equal(t, []string{"host:transport:abc", "reverse:forward:localabstract:foo;tcp:6000"}, s.Requests)
+	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "SendMessage", "ReadStatus", "ReadStatus", "Close", "Close"}, s.Trace)
+
+	s.Requests = nil
+	_, err = client.Reverse(TCPForwardSpec(7000), TCPForwardSpec(6000), true)
+	assert.NoError(t, err)
+	assert.Equal(t, "reverse:forward:norebind:tcp:7000;tcp:6000", s.Requests[1])
+}
+
+func TestReversePickedPort(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"38001"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	remote, err := client.Reverse(TCPForwardSpec(0), TCPForwardSpec(6000), false)
+	assert.NoError(t, err)
+	assert.Equal(t, TCPForwardSpec(38001), remote)
+	assert.Equal(t, "reverse:forward:tcp:0;tcp:6000", s.Requests[1])
+}
+
+func TestListReverses(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"UsbFfs tcp:7000 tcp:6000\nUsbFfs localabstract:foo tcp:6001\nUsbFfs vsock:2:80 tcp:6002\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	reverses, err := client.ListReverses()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host:transport:abc", "reverse:list-forward"}, s.Requests)
+	assert.Equal(t, []ReverseEntry{
+		{Remote: TCPForwardSpec(7000), Local: TCPForwardSpec(6000)},
+		{Remote: ForwardSpec{ForwardLocalAbstract, "foo"}, Local: TCPForwardSpec(6001)},
+		{Remote: ForwardSpec{"vsock", "2:80"}, Local: TCPForwardSpec(6002)},
+	}, reverses)
+}
+
+func TestRemoveReverse(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	assert.NoError(t, client.RemoveReverse(TCPForwardSpec(7000)))
+	assert.Equal(t, []string{"host:transport:abc", "reverse:killforward:tcp:7000"}, s.Requests)
+
+	s.Requests = nil
+	assert.NoError(t, client.RemoveAllReverses())
+	assert.Equal(t, []string{"host:transport:abc", "reverse:killforward-all"}, s.Requests)
+}
@@ -1,6 +1,7 @@
 package adb
 
 import (
+	"context"
 	stderrors "errors"
 	"fmt"
 	"os"
@@ -36,11 +37,12 @@ type ServerConfig struct {
 // Server knows how to start the adb server and connect to it.
 type server interface {
 	Start() error
-	Dial() (*wire.Conn, error)
+	// Dial connects to the server. The connection is closed when ctx is done.
+	Dial(ctx context.Context) (*wire.Conn, error)
 }
 
-func roundTripSingleResponse(s server, req string) ([]byte, error) {
-	conn, err := s.Dial()
+func roundTripSingleResponse(ctx context.Context, s server, req string) ([]byte, error) {
+	conn, err := s.Dial(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -91,15 +93,19 @@ func newServer(config ServerConfig) (server, error) {
 
 // Dial tries to connect to the server. If the first attempt fails, tries starting the server before
 // retrying. If the second attempt fails, returns the error.
-func (s *realServer) Dial() (*wire.Conn, error) {
-	conn, err := s.config.Dial(s.address)
+func (s *realServer) Dial(ctx context.Context) (*wire.Conn, error) {
+	conn, err := s.config.DialContext(ctx, s.address)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
 		// Attempt to start the server and try again.
 		if err = s.Start(); err != nil {
 			return nil, errors.WrapErrorf(err, errors.ServerNotAvailable, "error starting server for dial")
 		}
 
-		conn, err = s.config.Dial(s.address)
+		conn, err = s.config.DialContext(ctx, s.address)
 		if err != nil {
 			return nil, err
 		}
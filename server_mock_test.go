@@ -1,11 +1,17 @@
 package adb
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"strings"
+	"testing"
 
 	"github.com/drtechco/goadb/internal/errors"
 	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/require"
 )
 
 // MockServer implements Server, Scanner, and Sender.
@@ -30,7 +36,7 @@ type MockServer struct {
 
 var _ server = &MockServer{}
 
-func (s *MockServer) Dial() (*wire.Conn, error) {
+func (s *MockServer) Dial(ctx context.Context) (*wire.Conn, error) {
 	s.logMethod("Dial")
 	if err := s.getNextErrToReturn(); err != nil {
 		return nil, err
@@ -43,6 +49,25 @@ func (s *MockServer) Start() error {
 	return nil
 }
 
+// Read returns the remaining messages as a raw stream, one message per call.
+func (s *MockServer) Read(buf []byte) (int, error) {
+	s.logMethod("Read")
+	if err := s.getNextErrToReturn(); err != nil {
+		return 0, err
+	}
+	if s.nextMsgIndex >= len(s.Messages) {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, s.Messages[s.nextMsgIndex])
+	if n < len(s.Messages[s.nextMsgIndex]) {
+		s.Messages[s.nextMsgIndex] = s.Messages[s.nextMsgIndex][n:]
+	} else {
+		s.nextMsgIndex++
+	}
+	return n, nil
+}
+
 func (s *MockServer) ReadStatus(req string) (string, error) {
 	s.logMethod("ReadStatus")
 	if err := s.getNextErrToReturn(); err != nil {
@@ -77,6 +102,18 @@ func (s *MockServer) ReadUntilEof() ([]byte, error) {
 	return []byte(strings.Join(data, "")), nil
 }
 
+func (s *MockServer) ReadUntilEofV2WithStd(stdout io.Writer, stderr io.Writer) (int, error) {
+	s.logMethod("ReadUntilEofV2WithStd")
+	if err := s.getNextErrToReturn(); err != nil {
+		return -1, err
+	}
+
+	for ; s.nextMsgIndex < len(s.Messages); s.nextMsgIndex++ {
+		io.WriteString(stdout, s.Messages[s.nextMsgIndex])
+	}
+	return 0, nil
+}
+
 func (s *MockServer) SendMessage(msg []byte) error {
 	s.logMethod("SendMessage")
 	if err := s.getNextErrToReturn(); err != nil {
@@ -86,6 +123,16 @@ func (s *MockServer) SendMessage(msg []byte) error {
 	return nil
 }
 
+// Write appends data to Requests, as a separate request for each call.
+func (s *MockServer) Write(data []byte) (int, error) {
+	s.logMethod("Write")
+	if err := s.getNextErrToReturn(); err != nil {
+		return 0, err
+	}
+	s.Requests = append(s.Requests, string(data))
+	return len(data), nil
+}
+
 func (s *MockServer) NewSyncScanner() wire.SyncScanner {
 	s.logMethod("NewSyncScanner")
 	return nil
@@ -96,6 +143,19 @@ func (s *MockServer) NewSyncSender() wire.SyncSender {
 	return nil
 }
 
+func (s *MockServer) NewShellV2Writer() *wire.ShellV2Writer {
+	s.logMethod("NewShellV2Writer")
+	return wire.NewShellV2Writer(ioutil.Discard)
+}
+
+func (s *MockServer) CloseWrite() error {
+	s.logMethod("CloseWrite")
+	if err := s.getNextErrToReturn(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *MockServer) Close() error {
 	s.logMethod("Close")
 	if err := s.getNextErrToReturn(); err != nil {
@@ -115,3 +175,92 @@ func (s *MockServer) getNextErrToReturn() (err error) {
 func (s *MockServer) logMethod(name string) {
 	s.Trace = append(s.Trace, name)
 }
+
+// pipeServer runs itself on the server end of a net.Pipe for each connection, so requests and
+// responses go through the real wire scanner and sender. Unlike MockServer, it's safe to use from
+// multiple goroutines.
+type pipeServer func(conn net.Conn)
+
+var _ server = pipeServer(nil)
+
+func (s pipeServer) Start() error {
+	return nil
+}
+
+func (s pipeServer) Dial(ctx context.Context) (*wire.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		s(server)
+	}()
+
+	safeConn := wire.MultiCloseable(client)
+	return wire.NewConn(wire.NewScanner(safeConn), wire.NewSender(safeConn)), nil
+}
+
+// tcpServer serves connections on a real TCP socket, so half-closing works.
+type tcpServer struct {
+	address string
+}
+
+var _ server = tcpServer{}
+
+// newTCPServer listens on a local TCP port, and runs handle for each connection until the test
+// finishes.
+func newTCPServer(t *testing.T, handle func(conn net.Conn)) tcpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return tcpServer{listener.Addr().String()}
+}
+
+func (s tcpServer) Start() error {
+	return nil
+}
+
+func (s tcpServer) Dial(ctx context.Context) (*wire.Conn, error) {
+	return tcpDialer{}.DialContext(ctx, s.address)
+}
+
+// acceptRequests reads n requests from conn, replying OKAY to each like the adb server, and sends
+// them to requests. It returns false if conn fails first.
+func acceptRequests(conn net.Conn, n int, requests chan<- string) bool {
+	scanner := wire.NewScanner(conn)
+	for i := 0; i < n; i++ {
+		req, err := scanner.ReadMessage()
+		if err != nil {
+			return false
+		}
+		requests <- string(req)
+		if _, err := io.WriteString(conn, wire.StatusSuccess); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// newResponseServer returns a server that replies to a single request on each connection with
+// OKAY and response, and sends the requests to the returned channel.
+func newResponseServer(response string) (pipeServer, <-chan string) {
+	requests := make(chan string, 1)
+	return func(conn net.Conn) {
+		if acceptRequests(conn, 1, requests) {
+			io.WriteString(conn, fmt.Sprintf("%04x%s", len(response), response))
+		}
+	}, requests
+}
@@ -1,6 +1,7 @@
 package adb
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -40,6 +41,10 @@ func (d MockDialer) Dial(address string) (*wire.Conn, error) {
 	return nil, nil
 }
 
+func (d MockDialer) DialContext(ctx context.Context, address string) (*wire.Conn, error) {
+	return nil, nil
+}
+
 func TestNewServer_CustomConfig(t *testing.T) {
 	config := ServerConfig{
 		Dialer:    MockDialer{},
@@ -0,0 +1,151 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+// ShellOptions configures a shell session started with Device.StartShell.
+type ShellOptions struct {
+	// Command and Args to run. If Command is empty, an interactive shell is started.
+	// Args are quoted the same way as for RunCommand.
+	Command string
+	Args    []string
+
+	// Value of the TERM environment variable on the device.
+	// If empty, xterm-256color is used.
+	Term string
+
+	// If true, the device allocates a pseudo-terminal for the session. This is usually what you want
+	// for interactive programs, but it merges stderr into stdout.
+	PTY bool
+}
+
+/*
+ShellSession is a bidirectional "shell,v2" session running on a device.
+
+Stdout and Stderr must be read concurrently: the device sends both streams over the same
+connection, so a reader that stops reading one of them will eventually block the other.
+*/
+type ShellSession struct {
+	// Writes to Stdin are sent to the remote process. Closing it closes the remote stdin.
+	Stdin io.WriteCloser
+
+	Stdout io.Reader
+	Stderr io.Reader
+
+	conn   *wire.Conn
+	writer *wire.ShellV2Writer
+
+	// Closed when the session has ended, after which exitCode and err are set.
+	done     chan struct{}
+	exitCode int
+	err      error
+}
+
+/*
+StartShell starts a process on the device using the shell protocol, which allows writing to stdin,
+reading stdout and stderr separately, resizing the terminal, and getting the exit code.
+
+The connection is closed when ctx is cancelled.
+
+Corresponds to the command:
+
+	adb shell -T|-t [command]
+*/
+func (c *Device) StartShell(ctx context.Context, opts ShellOptions) (*ShellSession, error) {
+	cmd := ""
+	if opts.Command != "" {
+		var err error
+		cmd, err = prepareCommandLine(opts.Command, append([]string(nil), opts.Args...)...)
+		if err != nil {
+			return nil, wrapClientError(err, c, "StartShell")
+		}
+	}
+
+	term := opts.Term
+	if term == "" {
+		term = "xterm-256color"
+	}
+	ptyMode := "raw"
+	if opts.PTY {
+		ptyMode = "pty"
+	}
+	req := fmt.Sprintf("shell,v2,TERM=%s,%s:%s", term, ptyMode, cmd)
+
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "StartShell")
+	}
+	if err = conn.SendMessage([]byte(req)); err != nil {
+		conn.Close()
+		return nil, wrapClientError(err, c, "StartShell")
+	}
+	if _, err = conn.ReadStatus(req); err != nil {
+		conn.Close()
+		return nil, wrapClientError(err, c, "StartShell")
+	}
+
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+	session := &ShellSession{
+		Stdout: stdoutReader,
+		Stderr: stderrReader,
+		conn:   conn,
+		writer: conn.NewShellV2Writer(),
+		done:   make(chan struct{}),
+	}
+	session.Stdin = shellStdin{session.writer}
+
+	go func() {
+		defer close(session.done)
+
+		exitCode, err := conn.ReadUntilEofV2WithStd(stdoutWriter, stderrWriter)
+		session.exitCode = exitCode
+		session.err = wrapClientError(err, c, "StartShell")
+
+		stdoutWriter.CloseWithError(err)
+		stderrWriter.CloseWithError(err)
+		conn.Close()
+	}()
+
+	return session, nil
+}
+
+// Resize tells the device the terminal has changed size.
+// Only has an effect on sessions started with a PTY.
+func (s *ShellSession) Resize(rows, cols int) error {
+	return s.writer.WriteWindowSize(rows, cols, 0, 0)
+}
+
+// CloseStdin closes the remote process's stdin, e.g. to signal the end of input.
+func (s *ShellSession) CloseStdin() error {
+	return s.writer.CloseStdin()
+}
+
+// Wait blocks until the remote process exits and returns its exit code.
+func (s *ShellSession) Wait() (int, error) {
+	<-s.done
+	return s.exitCode, s.err
+}
+
+// Close terminates the session by closing the connection to the device.
+func (s *ShellSession) Close() error {
+	return s.conn.Close()
+}
+
+// shellStdin adapts a ShellV2Writer to the io.WriteCloser exposed as ShellSession.Stdin.
+type shellStdin struct {
+	writer *wire.ShellV2Writer
+}
+
+func (w shellStdin) Write(data []byte) (int, error) {
+	return w.writer.WriteStdin(data)
+}
+
+func (w shellStdin) Close() error {
+	return w.writer.CloseStdin()
+}
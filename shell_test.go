@@ -0,0 +1,50 @@
+package adb
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartShell(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"output"},
+	}
+	client := (&Adb{s}).Device(AnyDevice())
+
+	session, err := client.StartShell(context.Background(), ShellOptions{
+		Command: "cmd",
+		Args:    []string{"arg with spaces"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"host:transport-any", "shell,v2,TERM=xterm-256color,raw:cmd \"arg with spaces\""}, s.Requests)
+
+	output, err := ioutil.ReadAll(session.Stdout)
+	assert.NoError(t, err)
+	assert.Equal(t, "output", string(output))
+
+	exitCode, err := session.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exitCode)
+}
+
+func TestStartShellInteractive(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+	}
+	client := (&Adb{s}).Device(AnyDevice())
+
+	session, err := client.StartShell(context.Background(), ShellOptions{
+		Term: "dumb",
+		PTY:  true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "shell,v2,TERM=dumb,pty:", s.Requests[1])
+
+	_, err = session.Wait()
+	assert.NoError(t, err)
+}
@@ -1,7 +1,9 @@
 package adb
 
 import (
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"time"
 
@@ -11,51 +13,160 @@ import (
 
 var zeroTime = time.Unix(0, 0).UTC()
 
-func stat(conn *wire.SyncConn, path string) (*DirEntry, error) {
-	if err := conn.SendOctetString("STAT"); err != nil {
+// Flags sent with RCV2 and SND2 requests.
+const (
+	syncFlagNone   int32 = 0
+	syncFlagBrotli int32 = 1
+	syncFlagLZ4    int32 = 2
+	syncFlagZstd   int32 = 4
+)
+
+// syncProtocol selects which version of each sync message to use.
+type syncProtocol struct {
+	// Use LST2 instead of STAT, which reports 64-bit sizes, more attributes, and errors.
+	statV2 bool
+	// Use LIS2 instead of LIST, which reports the same attributes as LST2 for each entry.
+	lsV2 bool
+	// Use RCV2 and SND2 instead of RECV and SEND, which take flags.
+	sendRecvV2 bool
+	// Compression algorithms supported by RCV2 and SND2.
+	compressions map[Compression]bool
+}
+
+func newSyncProtocol(features FeatureSet) syncProtocol {
+	proto := syncProtocol{
+		statV2:       features.Has(FeatureStat2),
+		lsV2:         features.Has(FeatureLs2),
+		sendRecvV2:   features.Has(FeatureSendRecv2),
+		compressions: make(map[Compression]bool),
+	}
+	if proto.sendRecvV2 {
+		for _, c := range preferredCompressions {
+			proto.compressions[c] = features.Has(c.feature())
+		}
+	}
+	return proto
+}
+
+func stat(conn *wire.SyncConn, path string, proto syncProtocol) (*DirEntry, error) {
+	// STAT doesn't follow symlinks, so LST2 is its v2 equivalent, not STA2.
+	id := "STAT"
+	if proto.statV2 {
+		id = "LST2"
+	}
+	return statWithID(conn, path, id)
+}
+
+// statLinkTarget is like stat, but follows symlinks. Only the v2 STA2 message does, so it needs
+// the stat_v2 feature.
+func statLinkTarget(conn *wire.SyncConn, path string) (*DirEntry, error) {
+	return statWithID(conn, path, "STA2")
+}
+
+func statWithID(conn *wire.SyncConn, path string, id string) (*DirEntry, error) {
+	if err := conn.SendOctetString(id); err != nil {
 		return nil, err
 	}
 	if err := conn.SendBytes([]byte(path)); err != nil {
 		return nil, err
 	}
 
-	id, err := conn.ReadStatus("stat")
+	status, err := conn.ReadStatus("stat")
 	if err != nil {
 		return nil, err
 	}
-	if id != "STAT" {
-		return nil, errors.Errorf(errors.AssertionError, "expected stat ID 'STAT', but got '%s'", id)
+	if status != id {
+		return nil, errors.Errorf(errors.AssertionError, "expected stat ID '%s', but got '%s'", id, status)
 	}
 
-	return readStat(conn)
+	if id == "STAT" {
+		return readStat(conn)
+	}
+	return readStatV2(conn, path)
 }
 
-func listDirEntries(conn *wire.SyncConn, path string) (entries *DirEntries, err error) {
-	if err = conn.SendOctetString("LIST"); err != nil {
+func listDirEntries(conn *wire.SyncConn, path string, proto syncProtocol) (entries *DirEntries, err error) {
+	id := "LIST"
+	if proto.lsV2 {
+		id = "LIS2"
+	}
+
+	if err = conn.SendOctetString(id); err != nil {
 		return
 	}
 	if err = conn.SendBytes([]byte(path)); err != nil {
 		return
 	}
 
-	return &DirEntries{scanner: conn}, nil
+	return &DirEntries{scanner: conn, v2: proto.lsV2}, nil
 }
 
-func receiveFile(conn *wire.SyncConn, path string) (io.ReadCloser, error) {
-	if err := conn.SendOctetString("RECV"); err != nil {
+// receiveFile returns a ReadCloser that reads the file at path on the device.
+// The contents are compressed with compression while being transferred, which must be supported
+// by the device.
+func receiveFile(conn *wire.SyncConn, path string, proto syncProtocol, compression Compression) (io.ReadCloser, error) {
+	if err := requestFile(conn, path, proto, compression); err != nil {
 		return nil, err
 	}
-	if err := conn.SendBytes([]byte(path)); err != nil {
+
+	reader, err := newSyncFileReader(conn)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := newDecompressingReader(reader, compression)
+	if err != nil {
+		reader.Close()
 		return nil, err
 	}
-	return newSyncFileReader(conn)
+	return decompressed, nil
+}
+
+// requestFile sends the request to receive the file at path.
+func requestFile(conn *wire.SyncConn, path string, proto syncProtocol, compression Compression) error {
+	id := "RECV"
+	if proto.sendRecvV2 {
+		id = "RCV2"
+	}
+
+	if err := conn.SendOctetString(id); err != nil {
+		return err
+	}
+	if err := conn.SendBytes([]byte(path)); err != nil {
+		return err
+	}
+	if proto.sendRecvV2 {
+		return sendSyncFlags(conn, id, compression.flag())
+	}
+	return nil
 }
 
 // sendFile returns a WriteCloser than will write to the file at path on device.
 // The file will be created with permissions specified by mode.
 // The file's modified time will be set to mtime, unless mtime is 0, in which case the time the writer is
 // closed will be used.
-func sendFile(conn *wire.SyncConn, path string, mode os.FileMode, mtime time.Time) (io.WriteCloser, error) {
+// The contents are compressed with compression while being transferred, which must be supported
+// by the device.
+func sendFile(conn *wire.SyncConn, path string, mode os.FileMode, mtime time.Time, proto syncProtocol, compression Compression) (io.WriteCloser, error) {
+	if proto.sendRecvV2 {
+		if err := conn.SendOctetString("SND2"); err != nil {
+			return nil, err
+		}
+		if err := conn.SendBytes([]byte(path)); err != nil {
+			return nil, err
+		}
+		// The mode is sent in a second request, along with the flags.
+		if err := conn.SendOctetString("SND2"); err != nil {
+			return nil, err
+		}
+		if err := conn.SendFileMode(mode.Perm()); err != nil {
+			return nil, err
+		}
+		if err := conn.SendInt32(compression.flag()); err != nil {
+			return nil, err
+		}
+		return newCompressingWriter(newSyncFileWriter(conn, mtime), compression)
+	}
+
 	if err := conn.SendOctetString("SEND"); err != nil {
 		return nil, err
 	}
@@ -68,6 +179,45 @@ func sendFile(conn *wire.SyncConn, path string, mode os.FileMode, mtime time.Tim
 	return newSyncFileWriter(conn, mtime), nil
 }
 
+// readSendStatus reads the device's response to a file sent by sendFile, after the file has been
+// closed. The device only reports whether it could write the file, e.g. if it ran out of space,
+// once it has received all of it.
+func readSendStatus(s wire.SyncScanner) error {
+	if _, err := s.ReadStatus("write"); err != nil {
+		return err
+	}
+	// OKAY has a message length, which is always 0.
+	_, err := s.ReadInt32()
+	return err
+}
+
+// withSendStatus returns conn, except closing it reads the response to the file sent on it by
+// sendFile before closing the connection.
+func withSendStatus(conn *wire.SyncConn) *wire.SyncConn {
+	return &wire.SyncConn{
+		SyncScanner: statusScanner{conn.SyncScanner},
+		SyncSender:  conn.SyncSender,
+	}
+}
+
+// statusScanner reads the response to a sent file when it's closed, before closing the scanner.
+type statusScanner struct {
+	wire.SyncScanner
+}
+
+func (s statusScanner) Close() error {
+	err := readSendStatus(s.SyncScanner)
+	return errors.CombineErrs("error closing FileWriter", errors.NetworkError, err, s.SyncScanner.Close())
+}
+
+// sendSyncFlags sends the second request of a RCV2 transfer, which holds its flags.
+func sendSyncFlags(conn *wire.SyncConn, id string, flags int32) error {
+	if err := conn.SendOctetString(id); err != nil {
+		return err
+	}
+	return conn.SendInt32(flags)
+}
+
 func readStat(s wire.SyncScanner) (entry *DirEntry, err error) {
 	mode, err := s.ReadFileMode()
 	if err != nil {
@@ -93,8 +243,136 @@ func readStat(s wire.SyncScanner) (entry *DirEntry, err error) {
 
 	entry = &DirEntry{
 		Mode:       mode,
-		Size:       size,
+		Size:       int64(uint32(size)),
 		ModifiedAt: mtime,
 	}
 	return
 }
+
+// readStatV2 reads the rest of a LST2 or STA2 response, after the ID.
+func readStatV2(s wire.SyncScanner, path string) (*DirEntry, error) {
+	errno, err := s.ReadInt32()
+	if err != nil {
+		return nil, errors.WrapErrf(err, "error reading stat error: %v", err)
+	}
+	entry, err := readStatFieldsV2(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if errno != 0 {
+		return nil, remoteErrnoError(RemoteErrno(errno), path)
+	}
+	return entry, nil
+}
+
+// readStatFieldsV2 reads the attributes that LST2 and DNT2 messages have in common.
+func readStatFieldsV2(s wire.SyncScanner) (entry *DirEntry, err error) {
+	var dev, ino, size, atime, mtime, ctime int64
+	var mode os.FileMode
+	var nlink, uid, gid int32
+
+	if dev, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading device ID: %v", err)
+	}
+	if ino, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading inode: %v", err)
+	}
+	if mode, err = s.ReadFileMode(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading file mode: %v", err)
+	}
+	if nlink, err = s.ReadInt32(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading link count: %v", err)
+	}
+	if uid, err = s.ReadInt32(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading uid: %v", err)
+	}
+	if gid, err = s.ReadInt32(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading gid: %v", err)
+	}
+	if size, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading file size: %v", err)
+	}
+	if atime, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading access time: %v", err)
+	}
+	if mtime, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading modification time: %v", err)
+	}
+	if ctime, err = s.ReadInt64(); err != nil {
+		return nil, errors.WrapErrf(err, "error reading change time: %v", err)
+	}
+
+	return &DirEntry{
+		Mode:       mode,
+		Size:       size,
+		ModifiedAt: time.Unix(mtime, 0).UTC(),
+		UID:        uint32(uid),
+		GID:        uint32(gid),
+		Inode:      uint64(ino),
+		Nlink:      uint32(nlink),
+		Device:     uint64(dev),
+		AccessedAt: time.Unix(atime, 0).UTC(),
+		ChangedAt:  time.Unix(ctime, 0).UTC(),
+	}, nil
+}
+
+// Errno values the device may report. These are always the Linux values, regardless of the host.
+const (
+	remoteEPERM  RemoteErrno = 1
+	remoteENOENT RemoteErrno = 2
+	remoteEACCES RemoteErrno = 13
+	remoteEEXIST RemoteErrno = 17
+	remoteEISDIR RemoteErrno = 21
+	remoteEINVAL RemoteErrno = 22
+)
+
+var remoteErrnoMessages = map[RemoteErrno]string{
+	1:  "operation not permitted",
+	2:  "no such file or directory",
+	5:  "input/output error",
+	13: "permission denied",
+	17: "file exists",
+	20: "not a directory",
+	21: "is a directory",
+	22: "invalid argument",
+	28: "no space left on device",
+	30: "read-only file system",
+	36: "file name too long",
+	39: "directory not empty",
+	40: "too many levels of symbolic links",
+}
+
+// RemoteErrno is an errno value reported by the device in v2 sync responses, and is the cause of
+// errors returned from file operations that fail on the device. Values are the Linux ones,
+// regardless of the host OS.
+type RemoteErrno uint32
+
+func (e RemoteErrno) Error() string {
+	if msg, ok := remoteErrnoMessages[e]; ok {
+		return msg
+	}
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+// Is makes errors.Is match errnos against fs.ErrNotExist, fs.ErrExist and fs.ErrPermission, like
+// syscall.Errno.
+func (e RemoteErrno) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e == remoteENOENT
+	case fs.ErrExist:
+		return e == remoteEEXIST
+	case fs.ErrPermission:
+		return e == remoteEPERM || e == remoteEACCES
+	}
+	return false
+}
+
+func remoteErrnoError(errno RemoteErrno, path string) error {
+	code := errors.AdbError
+	if errno == remoteENOENT {
+		code = errors.FileNoExistError
+	}
+	return errors.WrapErrorf(errno, code, "%s: %s", path, errno)
+}
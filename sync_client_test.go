@@ -3,6 +3,9 @@ package adb
 
 import (
 	"bytes"
+	"encoding/binary"
+	stderrors "errors"
+	"io"
 	"os"
 	"testing"
 	"time"
@@ -17,7 +20,7 @@ var someTime = time.Date(2015, 5, 3, 8, 8, 8, 0, time.UTC)
 
 func TestStatValid(t *testing.T) {
 	var buf bytes.Buffer
-	conn := &wire.SyncConn{wire.NewSyncScanner(&buf), wire.NewSyncSender(&buf)}
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
 
 	var mode os.FileMode = 0777
 
@@ -26,36 +29,158 @@ func TestStatValid(t *testing.T) {
 	conn.SendInt32(4)
 	conn.SendTime(someTime)
 
-	entry, err := stat(conn, "/thing")
+	entry, err := stat(conn, "/thing", syncProtocol{})
 	assert.NoError(t, err)
 	require.NotNil(t, entry)
 	assert.Equal(t, mode, entry.Mode, "expected os.FileMode %s, got %s", mode, entry.Mode)
-	assert.Equal(t, int32(4), entry.Size)
+	assert.Equal(t, int64(4), entry.Size)
 	assert.Equal(t, someTime, entry.ModifiedAt)
 	assert.Equal(t, "", entry.Name)
 }
 
 func TestStatBadResponse(t *testing.T) {
 	var buf bytes.Buffer
-	conn := &wire.SyncConn{wire.NewSyncScanner(&buf), wire.NewSyncSender(&buf)}
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
 
 	conn.SendOctetString("SPAT")
 
-	entry, err := stat(conn, "/")
+	entry, err := stat(conn, "/", syncProtocol{})
 	assert.Nil(t, entry)
 	assert.Error(t, err)
 }
 
 func TestStatNoExist(t *testing.T) {
 	var buf bytes.Buffer
-	conn := &wire.SyncConn{wire.NewSyncScanner(&buf), wire.NewSyncSender(&buf)}
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
 
 	conn.SendOctetString("STAT")
 	conn.SendFileMode(0)
 	conn.SendInt32(0)
 	conn.SendTime(time.Unix(0, 0).UTC())
 
-	entry, err := stat(conn, "/")
+	entry, err := stat(conn, "/", syncProtocol{})
 	assert.Nil(t, entry)
 	assert.Equal(t, errors.FileNoExistError, err.(*errors.Err).Code)
 }
+
+// sendStatV2 writes the body of a LST2 or DNT2 message, after the ID.
+func sendStatV2(w io.Writer, errno uint32, mode uint32, size int64) {
+	binary.Write(w, binary.LittleEndian, errno)
+	binary.Write(w, binary.LittleEndian, int64(10))   // dev
+	binary.Write(w, binary.LittleEndian, int64(1234)) // ino
+	binary.Write(w, binary.LittleEndian, mode)
+	binary.Write(w, binary.LittleEndian, uint32(1))    // nlink
+	binary.Write(w, binary.LittleEndian, uint32(2000)) // uid
+	binary.Write(w, binary.LittleEndian, uint32(3000)) // gid
+	binary.Write(w, binary.LittleEndian, size)
+	binary.Write(w, binary.LittleEndian, someTime.Unix()-1) // atime
+	binary.Write(w, binary.LittleEndian, someTime.Unix())   // mtime
+	binary.Write(w, binary.LittleEndian, someTime.Unix()+1) // ctime
+}
+
+func TestStatV2Valid(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	conn.SendOctetString("LST2")
+	sendStatV2(&buf, 0, 0644, 5<<30)
+
+	entry, err := stat(conn, "/thing", syncProtocol{statV2: true})
+	assert.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, &DirEntry{
+		Mode:       0644,
+		Size:       5 << 30,
+		ModifiedAt: someTime,
+		UID:        2000,
+		GID:        3000,
+		Inode:      1234,
+		Nlink:      1,
+		Device:     10,
+		AccessedAt: someTime.Add(-time.Second),
+		ChangedAt:  someTime.Add(time.Second),
+	}, entry)
+	assert.Equal(t, "LST2\x06\x00\x00\x00/thing", buf.String())
+}
+
+func TestStatLinkTarget(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	conn.SendOctetString("STA2")
+	sendStatV2(&buf, 0, uint32(0040755), 4096)
+
+	entry, err := statLinkTarget(conn, "/sdcard")
+	assert.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.Mode.IsDir())
+	assert.Equal(t, "STA2\x07\x00\x00\x00/sdcard", buf.String())
+}
+
+func TestStatV2Errno(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	conn.SendOctetString("LST2")
+	sendStatV2(&buf, 2, 0, 0)
+	entry, err := stat(conn, "/thing", syncProtocol{statV2: true})
+	assert.Nil(t, entry)
+	assert.True(t, HasErrCode(err, FileNoExistError))
+
+	buf.Reset()
+	conn.SendOctetString("LST2")
+	sendStatV2(&buf, 13, 0, 0)
+	entry, err = stat(conn, "/thing", syncProtocol{statV2: true})
+	assert.Nil(t, entry)
+	assert.True(t, HasErrCode(err, AdbError))
+
+	var errno RemoteErrno
+	require.True(t, stderrors.As(err, &errno))
+	assert.Equal(t, RemoteErrno(13), errno)
+	assert.EqualError(t, errno, "permission denied")
+}
+
+func TestListDirEntriesV2(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	conn.SendOctetString("DNT2")
+	sendStatV2(&buf, 0, wire.ModeDir|0755, 4096)
+	conn.SendBytes([]byte("dir"))
+	conn.SendOctetString("DNT2")
+	sendStatV2(&buf, 13, 0, 0)
+	conn.SendBytes([]byte("secret"))
+	conn.SendOctetString("DONE")
+	sendStatV2(&buf, 0, 0, 0)
+	conn.SendBytes(nil)
+
+	entries := &DirEntries{scanner: conn, v2: true}
+	result, err := entries.ReadAll()
+	assert.NoError(t, err)
+	require.Len(t, result, 2)
+	assert.Equal(t, "dir", result[0].Name)
+	assert.Equal(t, 0755|os.ModeDir, result[0].Mode)
+	assert.Equal(t, int64(4096), result[0].Size)
+	assert.Equal(t, &DirEntry{Name: "secret"}, result[1])
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestReceiveFileV2Request(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	// The response is read from the front of the buffer, leaving only the request.
+	conn.SendOctetString("DONE")
+	_, err := receiveFile(conn, "/f", syncProtocol{sendRecvV2: true}, CompressionNone)
+	assert.NoError(t, err)
+	assert.Equal(t, "RCV2\x02\x00\x00\x00/fRCV2\x00\x00\x00\x00", buf.String())
+}
+
+func TestSendFileV2Request(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	_, err := sendFile(conn, "/f", 0644, someTime, syncProtocol{sendRecvV2: true}, CompressionNone)
+	assert.NoError(t, err)
+	assert.Equal(t, "SND2\x02\x00\x00\x00/fSND2\xa4\x01\x00\x00\x00\x00\x00\x00", buf.String())
+}
@@ -0,0 +1,187 @@
+package adb
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// Compression selects how file contents are compressed while being transferred to or from the
+// device. Compression requires the v2 sync protocol.
+type Compression int
+
+const (
+	// Don't compress. This is the default.
+	CompressionNone Compression = iota
+	// Use the best algorithm supported by the device, or don't compress if it doesn't support any.
+	CompressionAny
+	CompressionBrotli
+	CompressionLZ4
+	CompressionZstd
+)
+
+// Algorithms in the order CompressionAny prefers them.
+var preferredCompressions = []Compression{CompressionZstd, CompressionLZ4, CompressionBrotli}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionAny:
+		return "any"
+	case CompressionBrotli:
+		return "brotli"
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// feature returns the feature the device reports when it supports c.
+func (c Compression) feature() Feature {
+	return FeatureSendRecv2 + Feature("_"+c.String())
+}
+
+func (c Compression) flag() int32 {
+	switch c {
+	case CompressionBrotli:
+		return syncFlagBrotli
+	case CompressionLZ4:
+		return syncFlagLZ4
+	case CompressionZstd:
+		return syncFlagZstd
+	default:
+		return syncFlagNone
+	}
+}
+
+// resolveCompression returns the algorithm to use when requested is asked for, or an error if
+// the device doesn't support it.
+func (p syncProtocol) resolveCompression(requested Compression) (Compression, error) {
+	switch requested {
+	case CompressionNone:
+		return CompressionNone, nil
+	case CompressionAny:
+		for _, c := range preferredCompressions {
+			if p.compressions[c] {
+				return c, nil
+			}
+		}
+		return CompressionNone, nil
+	case CompressionBrotli, CompressionLZ4, CompressionZstd:
+		if !p.compressions[requested] {
+			return CompressionNone, errors.Errorf(errors.AdbError, "device doesn't support %s compression", requested)
+		}
+		return requested, nil
+	default:
+		return CompressionNone, errors.AssertionErrorf("invalid compression: %d", requested)
+	}
+}
+
+// newDecompressingReader returns a reader that decompresses the contents of r, and closes r when
+// it's closed.
+func newDecompressingReader(r io.ReadCloser, compression Compression) (io.ReadCloser, error) {
+	var decompressed io.Reader
+	switch compression {
+	case CompressionNone:
+		return r, nil
+	case CompressionBrotli:
+		decompressed = brotli.NewReader(r)
+	case CompressionLZ4:
+		decompressed = lz4.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, errors.WrapErrorf(err, errors.AssertionError, "error creating zstd decoder")
+		}
+		return &decompressingReader{Reader: decoder, source: r, release: decoder.Close}, nil
+	default:
+		return nil, errors.AssertionErrorf("invalid compression: %d", compression)
+	}
+	return &decompressingReader{Reader: decompressed, source: r}, nil
+}
+
+type decompressingReader struct {
+	io.Reader
+	source io.Closer
+
+	// Frees any resources held by the decompressor. May be nil.
+	release func()
+}
+
+func (r *decompressingReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	if err != nil && err != io.EOF {
+		if _, ok := err.(*errors.Err); !ok {
+			err = errors.WrapErrorf(err, errors.NetworkError, "error decompressing file")
+		}
+	}
+	return n, err
+}
+
+func (r *decompressingReader) Close() error {
+	if r.release != nil {
+		r.release()
+	}
+	return r.source.Close()
+}
+
+// newCompressingWriter returns a writer that compresses everything written to it before writing it
+// to w. Closing it flushes the compressor, then closes w.
+func newCompressingWriter(w io.WriteCloser, compression Compression) (io.WriteCloser, error) {
+	var compressor io.WriteCloser
+	switch compression {
+	case CompressionNone:
+		return w, nil
+	case CompressionBrotli:
+		compressor = brotli.NewWriter(w)
+	case CompressionLZ4:
+		lz4Writer := lz4.NewWriter(w)
+		// Keep blocks small so the device doesn't need large buffers to decompress them.
+		if err := lz4Writer.Apply(lz4.BlockSizeOption(lz4.Block64Kb)); err != nil {
+			return nil, errors.WrapErrorf(err, errors.AssertionError, "error configuring lz4 encoder")
+		}
+		compressor = lz4Writer
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, errors.WrapErrorf(err, errors.AssertionError, "error creating zstd encoder")
+		}
+		compressor = encoder
+	default:
+		return nil, errors.AssertionErrorf("invalid compression: %d", compression)
+	}
+	return &compressingWriter{compressor: compressor, dest: w}, nil
+}
+
+type compressingWriter struct {
+	compressor io.WriteCloser
+	dest       io.WriteCloser
+}
+
+func (w *compressingWriter) Write(buf []byte) (int, error) {
+	n, err := w.compressor.Write(buf)
+	if err != nil {
+		if _, ok := err.(*errors.Err); !ok {
+			err = errors.WrapErrorf(err, errors.NetworkError, "error compressing file")
+		}
+	}
+	return n, err
+}
+
+func (w *compressingWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		w.dest.Close()
+		if _, ok := err.(*errors.Err); !ok {
+			err = errors.WrapErrorf(err, errors.NetworkError, "error flushing compressed file")
+		}
+		return err
+	}
+	return w.dest.Close()
+}
@@ -0,0 +1,100 @@
+package adb
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCompression(t *testing.T) {
+	proto := newSyncProtocol(FeatureSet{
+		"sendrecv_v2":        true,
+		"sendrecv_v2_brotli": true,
+		"sendrecv_v2_lz4":    true,
+	})
+
+	c, err := proto.resolveCompression(CompressionAny)
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionLZ4, c)
+
+	c, err = proto.resolveCompression(CompressionBrotli)
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionBrotli, c)
+
+	c, err = proto.resolveCompression(CompressionNone)
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionNone, c)
+
+	_, err = proto.resolveCompression(CompressionZstd)
+	assert.True(t, HasErrCode(err, AdbError))
+}
+
+func TestResolveCompressionWithoutV2(t *testing.T) {
+	// Compression features are meaningless without sendrecv_v2.
+	proto := newSyncProtocol(FeatureSet{FeatureSendRecv2Zstd: true})
+
+	c, err := proto.resolveCompression(CompressionAny)
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionNone, c)
+
+	_, err = proto.resolveCompression(CompressionZstd)
+	assert.True(t, HasErrCode(err, AdbError))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	contents := strings.Repeat("hello world ", 10000)
+
+	for _, c := range []Compression{CompressionNone, CompressionBrotli, CompressionLZ4, CompressionZstd} {
+		t.Run(c.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newCompressingWriter(nopWriteCloser{&buf}, c)
+			require.NoError(t, err)
+			_, err = io.WriteString(w, contents)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Close())
+			if c != CompressionNone {
+				assert.True(t, buf.Len() < len(contents))
+			}
+
+			r, err := newDecompressingReader(ioutil.NopCloser(&buf), c)
+			require.NoError(t, err)
+			data, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, contents, string(data))
+		})
+	}
+}
+
+func TestReceiveFileCompressedRequest(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	conn.SendOctetString("DONE")
+	_, err := receiveFile(conn, "/f", syncProtocol{sendRecvV2: true}, CompressionZstd)
+	assert.NoError(t, err)
+	assert.Equal(t, "RCV2\x02\x00\x00\x00/fRCV2\x04\x00\x00\x00", buf.String())
+}
+
+func TestSendFileCompressedRequest(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &wire.SyncConn{SyncScanner: wire.NewSyncScanner(&buf), SyncSender: wire.NewSyncSender(&buf)}
+
+	_, err := sendFile(conn, "/f", 0644, someTime, syncProtocol{sendRecvV2: true}, CompressionLZ4)
+	assert.NoError(t, err)
+	assert.Equal(t, "SND2\x02\x00\x00\x00/fSND2\xa4\x01\x00\x00\x02\x00\x00\x00", buf.String())
+}
@@ -0,0 +1,267 @@
+package adb
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+/*
+SyncSession runs file operations on a single sync connection, instead of opening a new connection
+for each one like the methods on Device do.
+
+Operations run one at a time: readers, writers and directory listings must be closed (or, for
+listings, read to the end) before the next operation starts. Closing a reader before the end of the
+file reads and discards the rest of it. A SyncSession is not safe for concurrent use.
+
+If an operation fails in a way that leaves the connection unusable, like a file that can't be read
+or written, the session is closed and every later operation returns the same error.
+*/
+type SyncSession struct {
+	ctx    context.Context
+	device *Device
+	conn   *wire.SyncConn
+	proto  syncProtocol
+
+	// Passed to each operation instead of conn, so closing it finishes the operation instead
+	// of closing the connection.
+	lent *wire.SyncConn
+
+	// Reads the rest of the response to the operation in progress, if any.
+	finish func() error
+	// Set once the session can't be used anymore.
+	err error
+}
+
+/*
+OpenSync opens a connection for running many file operations in sequence. The connection is closed
+when the session is closed, or ctx is done.
+
+Corresponds to the command:
+
+	adb sync
+*/
+func (c *Device) OpenSync(ctx context.Context) (*SyncSession, error) {
+	conn, proto, err := c.getSyncConn(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "OpenSync")
+	}
+	return newSyncSession(ctx, c, conn, proto), nil
+}
+
+func newSyncSession(ctx context.Context, device *Device, conn *wire.SyncConn, proto syncProtocol) *SyncSession {
+	s := &SyncSession{
+		ctx:    ctx,
+		device: device,
+		conn:   conn,
+		proto:  proto,
+	}
+	s.lent = &wire.SyncConn{
+		SyncScanner: sessionScanner{conn.SyncScanner, s},
+		SyncSender:  sessionSender{conn.SyncSender, s},
+	}
+	return s
+}
+
+// Stat is like Device.Stat, but runs on the session.
+func (s *SyncSession) Stat(path string) (*DirEntry, error) {
+	if err := s.begin(); err != nil {
+		return nil, wrapClientError(err, s.device, "Stat(%s)", path)
+	}
+
+	entry, err := stat(s.lent, path, s.proto)
+	if err != nil && !HasErrCode(err, FileNoExistError) && !stderrors.As(err, new(RemoteErrno)) {
+		// Anything but a missing file means the response couldn't be read.
+		s.fail(err)
+	}
+	return entry, wrapClientError(err, s.device, "Stat(%s)", path)
+}
+
+// ListDirEntries is like Device.ListDirEntries, but runs on the session.
+func (s *SyncSession) ListDirEntries(path string) (*DirEntries, error) {
+	if err := s.begin(); err != nil {
+		return nil, wrapClientError(err, s.device, "ListDirEntries(%s)", path)
+	}
+
+	entries, err := listDirEntries(s.lent, path, s.proto)
+	if err != nil {
+		s.fail(err)
+		return nil, wrapClientError(err, s.device, "ListDirEntries(%s)", path)
+	}
+
+	s.finish = func() error {
+		if entries.err != nil {
+			return entries.err
+		}
+		for !entries.done {
+			_, done, err := entries.readNext()
+			if err != nil {
+				return err
+			}
+			entries.done = done
+		}
+		if !s.proto.lsV2 {
+			// The DONE message has the same layout as a DENT message, which isn't read by
+			// readNextDirListEntry: mode, size, time, and name length.
+			for i := 0; i < 4; i++ {
+				if _, err := s.conn.ReadInt32(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return entries, nil
+}
+
+// OpenRead is like Device.OpenRead, but runs on the session.
+func (s *SyncSession) OpenRead(path string) (io.ReadCloser, error) {
+	return s.OpenReadWithOptions(path, TransferOptions{})
+}
+
+// OpenReadWithOptions is like Device.OpenReadWithOptions, but runs on the session.
+func (s *SyncSession) OpenReadWithOptions(path string, opts TransferOptions) (io.ReadCloser, error) {
+	if err := s.begin(); err != nil {
+		return nil, wrapClientError(err, s.device, "OpenRead(%s)", path)
+	}
+
+	compression, err := s.proto.resolveCompression(opts.Compression)
+	if err != nil {
+		return nil, wrapClientError(err, s.device, "OpenRead(%s)", path)
+	}
+	if err := requestFile(s.lent, path, s.proto, compression); err != nil {
+		s.fail(err)
+		return nil, wrapClientError(err, s.device, "OpenRead(%s)", path)
+	}
+	raw, err := newSyncFileReader(s.lent)
+	if err != nil {
+		s.fail(err)
+		return nil, wrapClientError(err, s.device, "OpenRead(%s)", path)
+	}
+
+	s.finish = func() error {
+		if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+			return err
+		}
+		// The DONE chunk has a length, which isn't read by readNextChunk.
+		_, err := s.conn.ReadInt32()
+		return err
+	}
+
+	reader, err := newDecompressingReader(raw, compression)
+	if err != nil {
+		raw.Close()
+		return nil, wrapClientError(err, s.device, "OpenRead(%s)", path)
+	}
+	return opts.meterReadCloser(s.ctx, reader, path, 0, -1), nil
+}
+
+// OpenWrite is like Device.OpenWrite, but runs on the session. Closing the writer waits for the
+// device to report whether the file was written.
+func (s *SyncSession) OpenWrite(path string, perms os.FileMode, mtime time.Time) (io.WriteCloser, error) {
+	return s.OpenWriteWithOptions(path, perms, mtime, TransferOptions{})
+}
+
+// OpenWriteWithOptions is like Device.OpenWriteWithOptions, but runs on the session.
+func (s *SyncSession) OpenWriteWithOptions(path string, perms os.FileMode, mtime time.Time, opts TransferOptions) (io.WriteCloser, error) {
+	if err := s.begin(); err != nil {
+		return nil, wrapClientError(err, s.device, "OpenWrite(%s)", path)
+	}
+
+	compression, err := s.proto.resolveCompression(opts.Compression)
+	if err != nil {
+		return nil, wrapClientError(err, s.device, "OpenWrite(%s)", path)
+	}
+	writer, err := sendFile(s.lent, path, perms, mtime, s.proto, compression)
+	if err != nil {
+		s.fail(err)
+		return nil, wrapClientError(err, s.device, "OpenWrite(%s)", path)
+	}
+
+	s.finish = func() error {
+		return readSendStatus(s.conn)
+	}
+	return opts.meterWriteCloser(s.ctx, writer, path), nil
+}
+
+// Close ends the session and closes the connection. If an operation is still in progress,
+// the connection is closed without waiting for it.
+func (s *SyncSession) Close() error {
+	if s.err != nil {
+		return nil
+	}
+	s.err = errors.Errorf(errors.AssertionError, "sync session is closed")
+
+	if s.finish != nil {
+		s.finish = nil
+		return s.conn.Close()
+	}
+
+	err := s.conn.SendOctetString("QUIT")
+	if err == nil {
+		err = s.conn.SendInt32(0)
+	}
+	return errors.CombineErrs("error closing sync session", errors.NetworkError, err, s.conn.Close())
+}
+
+// begin checks that a new operation can start.
+func (s *SyncSession) begin() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.finish != nil {
+		return errors.AssertionErrorf("the previous operation on the sync session must be closed first")
+	}
+	return nil
+}
+
+// endOperation reads the rest of the response to the operation in progress, so the next one can
+// start.
+func (s *SyncSession) endOperation() error {
+	finish := s.finish
+	if finish == nil {
+		return nil
+	}
+	s.finish = nil
+
+	if err := finish(); err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail closes the connection, and makes every later operation return err.
+func (s *SyncSession) fail(err error) {
+	s.err = err
+	s.finish = nil
+	s.conn.Close()
+}
+
+// sessionScanner ends the operation in progress on a session when it's closed, instead of closing
+// the connection.
+type sessionScanner struct {
+	wire.SyncScanner
+	session *SyncSession
+}
+
+func (s sessionScanner) Close() error {
+	return s.session.endOperation()
+}
+
+// sessionSender ends the operation in progress on a session when it's closed, instead of closing
+// the connection.
+type sessionSender struct {
+	wire.SyncSender
+	session *SyncSession
+}
+
+func (s sessionSender) Close() error {
+	return s.session.endOperation()
+}
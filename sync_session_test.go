@@ -0,0 +1,146 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSyncSession returns a session that reads the responses written to responses, and writes
+// its requests to requests.
+func newTestSyncSession(responses, requests *bytes.Buffer, proto syncProtocol) *SyncSession {
+	conn := &wire.SyncConn{
+		SyncScanner: wire.NewSyncScanner(responses),
+		SyncSender:  wire.NewSyncSender(requests),
+	}
+	return newSyncSession(context.Background(), (&Adb{&MockServer{}}).Device(AnyDevice()), conn, proto)
+}
+
+func TestSyncSessionSequentialOperations(t *testing.T) {
+	var responses, requests bytes.Buffer
+	server := wire.NewSyncSender(&responses)
+	// Stat.
+	server.SendOctetString("STAT")
+	server.SendFileMode(0644)
+	server.SendInt32(11)
+	server.SendTime(someTime)
+	// OpenRead, in two chunks.
+	server.SendOctetString(wire.StatusSyncData)
+	server.SendBytes([]byte("hello"))
+	server.SendOctetString(wire.StatusSyncData)
+	server.SendBytes([]byte(" world"))
+	server.SendOctetString(wire.StatusSyncDone)
+	server.SendInt32(0)
+	// ListDirEntries.
+	server.SendOctetString("DENT")
+	server.SendFileMode(0644)
+	server.SendInt32(11)
+	server.SendTime(someTime)
+	server.SendBytes([]byte("file"))
+	server.SendOctetString("DONE")
+	responses.Write(make([]byte, 16))
+	// OpenWrite.
+	server.SendOctetString(wire.StatusSuccess)
+	server.SendInt32(0)
+
+	session := newTestSyncSession(&responses, &requests, syncProtocol{})
+
+	entry, err := session.Stat("/f")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), entry.Size)
+
+	reader, err := session.OpenRead("/f")
+	require.NoError(t, err)
+	// Closing early reads the rest of the file.
+	_, err = reader.Read(make([]byte, 2))
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Close())
+
+	entries, err := session.ListDirEntries("/")
+	require.NoError(t, err)
+	all, err := entries.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	writer, err := session.OpenWrite("/g", 0644, someTime)
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("hi"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	assert.NoError(t, session.Close())
+	assert.Equal(t, 0, responses.Len())
+
+	client := wire.NewSyncScanner(&requests)
+	for _, expected := range []string{"STAT", "RECV", "LIST", "SEND"} {
+		id, err := client.ReadStatus("")
+		require.NoError(t, err)
+		assert.Equal(t, expected, id)
+		_, err = client.ReadString()
+		require.NoError(t, err)
+	}
+	rest, _ := ioutil.ReadAll(&requests)
+	assert.Equal(t, "DATA\x02\x00\x00\x00hiDONE"+string(rest[14:18])+"QUIT\x00\x00\x00\x00", string(rest))
+}
+
+func TestSyncSessionWriteFailure(t *testing.T) {
+	var responses, requests bytes.Buffer
+	server := wire.NewSyncSender(&responses)
+	server.SendOctetString("FAIL")
+	server.SendBytes([]byte("Read-only file system"))
+
+	session := newTestSyncSession(&responses, &requests, syncProtocol{})
+	writer, err := session.OpenWrite("/system/f", 0644, someTime)
+	require.NoError(t, err)
+	err = writer.Close()
+	assert.True(t, HasErrCode(err, AdbError))
+
+	// The device closes the connection after a failure.
+	_, err = session.Stat("/f")
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.NoError(t, session.Close())
+}
+
+func TestSyncSessionOperationInProgress(t *testing.T) {
+	var responses, requests bytes.Buffer
+	server := wire.NewSyncSender(&responses)
+	server.SendOctetString(wire.StatusSyncData)
+	server.SendBytes([]byte("hello"))
+
+	session := newTestSyncSession(&responses, &requests, syncProtocol{})
+	_, err := session.OpenRead("/f")
+	require.NoError(t, err)
+
+	_, err = session.Stat("/f")
+	assert.True(t, HasErrCode(err, AssertionError))
+	assert.NoError(t, session.Close())
+
+	_, err = session.Stat("/f")
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestSyncSessionStatNoExist(t *testing.T) {
+	var responses, requests bytes.Buffer
+	server := wire.NewSyncSender(&responses)
+	server.SendOctetString("STAT")
+	server.SendFileMode(0)
+	server.SendInt32(0)
+	server.SendInt32(0)
+	server.SendOctetString("STAT")
+	server.SendFileMode(0755)
+	server.SendInt32(0)
+	server.SendTime(someTime)
+
+	session := newTestSyncSession(&responses, &requests, syncProtocol{})
+	_, err := session.Stat("/missing")
+	assert.True(t, HasErrCode(err, FileNoExistError))
+
+	// A missing file doesn't end the session.
+	_, err = session.Stat("/")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,462 @@
+package adb
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// TransferOptions configures how file contents are transferred to or from the device.
+type TransferOptions struct {
+	// Compression to use while transferring. See Compression for details.
+	Compression Compression
+
+	// Progress, if not nil, is called as the file is transferred, at most every 100ms, and once
+	// more when it's finished. It's called on the goroutine doing the transfer, so it should
+	// return quickly.
+	Progress func(TransferProgress)
+
+	// RateLimiter, if not nil, limits the rate of the transfer. It can be shared by many transfers
+	// to limit their combined rate.
+	RateLimiter *RateLimiter
+}
+
+// PullOptions configures PullWithOptions.
+type PullOptions struct {
+	TransferOptions
+
+	// Resume continues an interrupted pull instead of starting over. localFile must implement
+	// io.Seeker, and its contents are assumed to be the start of the remote file: only the rest
+	// of the file is pulled, and appended to it. The rest is read with tail, so it isn't
+	// compressed.
+	Resume bool
+
+	// Verify checks the pulled file against a hash computed on the device. When resuming, the
+	// hash covers the part that was already pulled too, so localFile must also implement
+	// io.Reader.
+	Verify Verification
+}
+
+// defaultPushPerms are the permissions of pushed files, if not set by PushOptions.
+const defaultPushPerms os.FileMode = 0666
+
+// PushOptions configures PushWithOptions.
+type PushOptions struct {
+	TransferOptions
+
+	// Mode is the permissions of the remote file. If zero, it's 0666, or the local file's
+	// permissions if Preserve is set. Only the permission bits are used.
+	Mode os.FileMode
+
+	// Mtime is the modification time of the remote file. If zero, it's the time the push
+	// finishes, or the local file's modification time if Preserve is set.
+	Mtime time.Time
+
+	// Preserve gives the remote file the permissions and modification time of the local file,
+	// unless Mode or Mtime are set. localFile must have a Stat method, like *os.File.
+	Preserve bool
+
+	// Atomic writes the file to a temporary name in the same directory, and renames it once it's
+	// finished, and verified if Verify is set. Other processes never see a partial file, and a
+	// failed push leaves the existing file intact. It can't be combined with Resume.
+	Atomic bool
+
+	// MkdirAll creates the parent directories of the remote file, with permissions 0755, if they
+	// don't exist.
+	MkdirAll bool
+
+	// Resume continues an interrupted push instead of starting over. localFile must implement
+	// io.Seeker, and is read from the start. If the remote file is a prefix of localFile, which is
+	// checked by comparing hashes, the rest is appended to it with cat, uncompressed. Otherwise,
+	// the whole file is pushed.
+	Resume bool
+
+	// Verify checks the pushed file against a hash computed on the device.
+	Verify Verification
+}
+
+// OpenReadWithOptions is like OpenReadContext, but transfers the file as configured by opts.
+func (c *Device) OpenReadWithOptions(ctx context.Context, path string, opts TransferOptions) (io.ReadCloser, error) {
+	conn, proto, err := c.getSyncConn(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "OpenRead(%s)", path)
+	}
+
+	compression, err := proto.resolveCompression(opts.Compression)
+	if err != nil {
+		conn.Close()
+		return nil, wrapClientError(err, c, "OpenRead(%s)", path)
+	}
+
+	reader, err := receiveFile(conn, path, proto, compression)
+	if err != nil {
+		return nil, wrapClientError(err, c, "OpenRead(%s)", path)
+	}
+	return opts.meterReadCloser(ctx, reader, path, 0, -1), nil
+}
+
+// OpenWriteWithOptions is like OpenWriteContext, but transfers the file as configured by opts.
+func (c *Device) OpenWriteWithOptions(ctx context.Context, path string, perms os.FileMode, mtime time.Time, opts TransferOptions) (io.WriteCloser, error) {
+	conn, proto, err := c.getSyncConn(ctx)
+	if err != nil {
+		return nil, wrapClientError(err, c, "OpenWrite(%s)", path)
+	}
+
+	compression, err := proto.resolveCompression(opts.Compression)
+	if err != nil {
+		conn.Close()
+		return nil, wrapClientError(err, c, "OpenWrite(%s)", path)
+	}
+
+	// Closing the writer waits for the device to report whether it could write the file.
+	writer, err := sendFile(withSendStatus(conn), path, perms, mtime, proto, compression)
+	if err != nil {
+		conn.Close()
+		return nil, wrapClientError(err, c, "OpenWrite(%s)", path)
+	}
+	return opts.meterWriteCloser(ctx, writer, path), nil
+}
+
+// PullWithOptions is like PullContext, but transfers the file as configured by opts.
+func (c *Device) PullWithOptions(ctx context.Context, remotePath string, localFile io.Writer, opts PullOptions) error {
+	if remotePath == "" {
+		return errors.Errorf(errors.AssertionError, "remotePath cannot be empty")
+	}
+	if localFile == nil {
+		return errors.Errorf(errors.AssertionError, "localFile cannot be nil")
+	}
+	sum, err := opts.Verify.newHash()
+	if err != nil {
+		return err
+	}
+	info, err := c.StatContext(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if opts.Resume {
+		if offset, err = pullResumeOffset(localFile, sum); err != nil {
+			return err
+		}
+		if offset > info.Size {
+			return errors.Errorf(errors.AdbError, "can't resume pull of %s: local file is larger than the remote one", remotePath)
+		}
+	}
+
+	if offset < info.Size {
+		dst := localFile
+		if sum != nil {
+			dst = io.MultiWriter(localFile, sum)
+		}
+		if err := c.pullFrom(ctx, remotePath, dst, offset, info.Size, opts.TransferOptions); err != nil {
+			return err
+		}
+	}
+
+	if sum != nil {
+		return c.verifyChecksum(ctx, remotePath, opts.Verify, sum)
+	}
+	return nil
+}
+
+// pullFrom copies the remote file from offset to size into dst.
+func (c *Device) pullFrom(ctx context.Context, remotePath string, dst io.Writer, offset, size int64, opts TransferOptions) error {
+	if offset > 0 {
+		return c.pullTail(ctx, remotePath, dst, offset, size, opts)
+	}
+
+	remoteFile, err := c.OpenReadWithOptions(ctx, remotePath, opts.withoutMeter())
+	if err != nil {
+		return err
+	}
+	defer remoteFile.Close()
+
+	src := opts.meterReader(ctx, remoteFile, remotePath, 0, size)
+	_, err = io.CopyN(dst, src, size)
+	return err
+}
+
+// pullTail is like pullFrom, but reads the file with tail, since the sync protocol can't start
+// reading in the middle of a file.
+func (c *Device) pullTail(ctx context.Context, remotePath string, dst io.Writer, offset, size int64, opts TransferOptions) error {
+	// tail's errors are discarded so they don't end up in dst. Its exit status says if it failed.
+	commandLine := shellCommandLine("tail", "-c", fmt.Sprintf("+%d", offset+1), "--", remotePath) + " 2>/dev/null"
+	conn, err := c.openService(ctx, statusService(commandLine))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Until the connection is closed, the end of the output could be the exit status.
+	output := &statusTrailerWriter{w: dst}
+	src := opts.meterReader(ctx, conn, remotePath, offset, size)
+	_, copyErr := io.CopyN(output, src, size-offset)
+	// Anything else before the status was appended to the file after it was stat'ed.
+	rest, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return errors.WrapErrorf(err, errors.NetworkError, "error reading output of tail")
+	}
+	_, status, err := parseExitStatus(string(output.tail) + string(rest))
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return fileCommandError(remotePath, "", status)
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	// The whole file was copied, so what was held back is part of it.
+	_, err = dst.Write(output.tail)
+	return err
+}
+
+// pullResumeOffset returns the size of localFile, which is where a resumed pull starts, and leaves
+// it positioned at the end. If sum isn't nil, the contents of localFile are written to it.
+func pullResumeOffset(localFile io.Writer, sum hash.Hash) (int64, error) {
+	seeker, ok := localFile.(io.Seeker)
+	if !ok {
+		return 0, errors.AssertionErrorf("localFile must implement io.Seeker to resume a pull")
+	}
+	if sum == nil {
+		return seeker.Seek(0, io.SeekEnd)
+	}
+
+	reader, ok := localFile.(io.Reader)
+	if !ok {
+		return 0, errors.AssertionErrorf("localFile must implement io.Reader to verify a resumed pull")
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(sum, reader)
+}
+
+// PushWithOptions is like PushContext, but transfers the file as configured by opts.
+func (c *Device) PushWithOptions(ctx context.Context, localFile io.Reader, remotePath string, opts PushOptions) error {
+	if remotePath == "" {
+		return errors.Errorf(errors.AssertionError, "remotePath cannot be empty")
+	}
+	if localFile == nil {
+		return errors.Errorf(errors.AssertionError, "localFile cannot be nil")
+	}
+	if opts.Atomic && opts.Resume {
+		return errors.Errorf(errors.AssertionError, "atomic pushes can't be resumed")
+	}
+	sum, err := opts.Verify.newHash()
+	if err != nil {
+		return err
+	}
+	perms, mtime, err := opts.attributes(localFile)
+	if err != nil {
+		return err
+	}
+
+	if opts.MkdirAll {
+		if err := c.MkdirAll(ctx, path.Dir(remotePath), 0755); err != nil {
+			return err
+		}
+	}
+
+	resumed := false
+	if opts.Resume {
+		if resumed, err = c.resumePush(ctx, localFile, remotePath, opts, sum); err != nil {
+			return err
+		}
+	}
+	if resumed {
+		// Appending to the file doesn't set its attributes, like pushing all of it does.
+		if err := c.Chmod(ctx, remotePath, perms); err != nil {
+			return err
+		}
+		if !mtime.IsZero() {
+			if err := c.setModTime(ctx, remotePath, mtime); err != nil {
+				return err
+			}
+		}
+	}
+
+	targetPath := remotePath
+	if opts.Atomic {
+		targetPath = atomicPushPath(remotePath)
+	}
+	if !resumed {
+		src := opts.meterReader(ctx, localFile, remotePath, 0, readerSize(localFile))
+		if sum != nil {
+			src = io.TeeReader(src, sum)
+		}
+		if err := c.pushTo(ctx, src, targetPath, perms, mtime, opts.withoutMeter()); err != nil {
+			if opts.Atomic {
+				c.RemoveAll(ctx, targetPath)
+			}
+			return err
+		}
+	}
+
+	if sum != nil {
+		if err := c.verifyChecksum(ctx, targetPath, opts.Verify, sum); err != nil {
+			if opts.Atomic {
+				c.RemoveAll(ctx, targetPath)
+			}
+			return err
+		}
+	}
+	if opts.Atomic {
+		if err := c.Rename(ctx, targetPath, remotePath); err != nil {
+			c.RemoveAll(ctx, targetPath)
+			return err
+		}
+	}
+	return nil
+}
+
+// pushTo writes src to remotePath, and waits for the device to finish writing it.
+func (c *Device) pushTo(ctx context.Context, src io.Reader, remotePath string, perms os.FileMode, mtime time.Time, opts TransferOptions) error {
+	writer, err := c.OpenWriteWithOptions(ctx, remotePath, perms, mtime, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, src); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// attributes returns the permissions and modification time to push a file with.
+func (opts PushOptions) attributes(localFile io.Reader) (os.FileMode, time.Time, error) {
+	perms, mtime := opts.Mode, opts.Mtime
+	if opts.Preserve {
+		statter, ok := localFile.(interface{ Stat() (os.FileInfo, error) })
+		if !ok {
+			return 0, time.Time{}, errors.AssertionErrorf("localFile must have a Stat method to preserve its attributes")
+		}
+		info, err := statter.Stat()
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if perms == 0 {
+			perms = info.Mode().Perm()
+		}
+		if mtime.IsZero() {
+			mtime = info.ModTime()
+		}
+	}
+	if perms == 0 {
+		perms = defaultPushPerms
+	}
+	return perms.Perm(), mtime, nil
+}
+
+// atomicPushPath returns the temporary path that an atomic push to remotePath writes to. It's in
+// the same directory, so it can be renamed without copying.
+func atomicPushPath(remotePath string) string {
+	dir, name := path.Split(remotePath)
+	return fmt.Sprintf("%s.%s.%d.tmp", dir, name, time.Now().UnixNano())
+}
+
+// resumePush appends the part of localFile that's missing from the remote file, if the remote
+// file is a prefix of it. Otherwise, it returns false with localFile positioned at the start, and
+// sum reset. If sum isn't nil, all of localFile is written to it.
+func (c *Device) resumePush(ctx context.Context, localFile io.Reader, remotePath string, opts PushOptions, sum hash.Hash) (bool, error) {
+	seeker, ok := localFile.(io.Seeker)
+	if !ok {
+		return false, errors.AssertionErrorf("localFile must implement io.Seeker to resume a push")
+	}
+	localSize, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	info, err := c.StatContext(ctx, remotePath)
+	if HasErrCode(err, FileNoExistError) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !info.Mode.IsRegular() || info.Size == 0 || info.Size > localSize {
+		return false, nil
+	}
+
+	// Compare the part that was already pushed.
+	v := opts.Verify
+	if v == VerifyNone {
+		v = VerifySHA256
+	}
+	prefixSum := sum
+	if prefixSum == nil {
+		prefixSum, _ = v.newHash()
+	}
+	if _, err := io.CopyN(prefixSum, localFile, info.Size); err != nil {
+		return false, err
+	}
+	remoteSum, err := c.remoteChecksum(ctx, remotePath, v, info.Size)
+	if err != nil {
+		return false, err
+	}
+	if hex.EncodeToString(prefixSum.Sum(nil)) != remoteSum {
+		prefixSum.Reset()
+		_, err := seeker.Seek(0, io.SeekStart)
+		return false, err
+	}
+
+	// Append the rest.
+	src := opts.meterReader(ctx, localFile, remotePath, info.Size, localSize)
+	if sum != nil {
+		src = io.TeeReader(src, sum)
+	}
+	output, err := c.ExecIn(ctx, src, "cat >> "+shellQuote(remotePath)+" 2>&1")
+	if err != nil {
+		return false, err
+	}
+	defer output.Close()
+	message, err := ioutil.ReadAll(output)
+	if err != nil {
+		return false, err
+	}
+	if len(message) > 0 {
+		return false, fileCommandError(remotePath, string(message), 1)
+	}
+
+	// Older adb servers may close the connection before cat is done, so check that it finished.
+	if info, err = c.StatContext(ctx, remotePath); err != nil {
+		return false, err
+	}
+	if info.Size != localSize {
+		return false, errors.Errorf(errors.AdbError, "resumed push of %s stopped at %d of %d bytes", remotePath, info.Size, localSize)
+	}
+	return true, nil
+}
+
+// readerSize returns the number of bytes left in r, or -1 if it can't be determined without
+// reading it.
+func readerSize(r io.Reader) int64 {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - current
+}
@@ -0,0 +1,246 @@
+package adb
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum time between calls to a progress callback, except for the
+// last one.
+const progressInterval = 100 * time.Millisecond
+
+// meterChunkSize is the most data a meter counts at once, so rate limits and progress are smooth
+// even when reading or writing large buffers.
+const meterChunkSize = 32 * 1024
+
+// TransferProgress describes how far a file transfer has got.
+type TransferProgress struct {
+	// Path of the file on the device.
+	Path string
+	// Done is the number of bytes transferred so far. For resumed transfers, it includes the part
+	// that was transferred before.
+	Done int64
+	// Total is the size of the file, or -1 if it's not known.
+	Total int64
+	// Rate is the average transfer rate so far, in bytes per second.
+	Rate float64
+	// ETA is the estimated time until the transfer is finished, or 0 if it can't be estimated.
+	ETA time.Duration
+}
+
+/*
+RateLimiter limits the rate of file transfers. A single RateLimiter can be shared by any number of
+transfers, even to different devices, to limit their combined rate, e.g. to avoid saturating a
+USB hub. It's safe for concurrent use.
+*/
+type RateLimiter struct {
+	bytesPerSecond int64
+
+	lock sync.Mutex
+	// When the next chunk of data can be transferred.
+	next time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows bytesPerSecond bytes per second. It panics if
+// bytesPerSecond isn't positive.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		panic("bytesPerSecond must be positive")
+	}
+	return &RateLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// wait blocks until n more bytes can be transferred, or ctx is done.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.lock.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		// Time spent idle isn't saved up for later bursts.
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withoutMeter returns opts without a progress callback or rate limit, for callers that meter the
+// transfer themselves.
+func (opts TransferOptions) withoutMeter() TransferOptions {
+	opts.Progress = nil
+	opts.RateLimiter = nil
+	return opts
+}
+
+// meterReader returns a reader that reads from r, reporting progress and limiting the rate as
+// configured by opts. done is the number of bytes of the file already transferred, and total its
+// size, or -1 if it's not known.
+func (opts TransferOptions) meterReader(ctx context.Context, r io.Reader, path string, done, total int64) io.Reader {
+	if opts.Progress == nil && opts.RateLimiter == nil {
+		return r
+	}
+	return &meteredReader{r, newTransferMeter(ctx, opts, path, done, total)}
+}
+
+// meterReadCloser is like meterReader, but also closes r.
+func (opts TransferOptions) meterReadCloser(ctx context.Context, r io.ReadCloser, path string, done, total int64) io.ReadCloser {
+	if opts.Progress == nil && opts.RateLimiter == nil {
+		return r
+	}
+	return meteredReadCloser{opts.meterReader(ctx, r, path, done, total), r}
+}
+
+// meterWriteCloser returns a writer that writes to w, reporting progress and limiting the rate as
+// configured by opts. The size of the file isn't known.
+func (opts TransferOptions) meterWriteCloser(ctx context.Context, w io.WriteCloser, path string) io.WriteCloser {
+	if opts.Progress == nil && opts.RateLimiter == nil {
+		return w
+	}
+	return &meteredWriter{w, newTransferMeter(ctx, opts, path, 0, -1)}
+}
+
+// transferMeter counts the bytes transferred for a file, waits for the rate limiter, and reports
+// progress.
+type transferMeter struct {
+	ctx         context.Context
+	progress    func(TransferProgress)
+	limiter     *RateLimiter
+	path        string
+	total       int64
+	done        int64
+	startDone   int64
+	start       time.Time
+	lastReport  time.Time
+	reportedEnd bool
+}
+
+func newTransferMeter(ctx context.Context, opts TransferOptions, path string, done, total int64) *transferMeter {
+	return &transferMeter{
+		ctx:       ctx,
+		progress:  opts.Progress,
+		limiter:   opts.RateLimiter,
+		path:      path,
+		total:     total,
+		done:      done,
+		startDone: done,
+		start:     time.Now(),
+	}
+}
+
+// wait blocks until the rate limiter allows n more bytes.
+func (m *transferMeter) wait(n int) error {
+	if m.limiter == nil {
+		return nil
+	}
+	return m.limiter.wait(m.ctx, n)
+}
+
+// add counts n more bytes as transferred.
+func (m *transferMeter) add(n int) {
+	m.done += int64(n)
+	if m.total >= 0 && m.done >= m.total {
+		m.finish()
+	} else if time.Since(m.lastReport) >= progressInterval {
+		m.report()
+	}
+}
+
+// finish reports the final progress, if it hasn't been already.
+func (m *transferMeter) finish() {
+	if !m.reportedEnd {
+		m.reportedEnd = true
+		m.report()
+	}
+}
+
+func (m *transferMeter) report() {
+	if m.progress == nil {
+		return
+	}
+	now := time.Now()
+	m.lastReport = now
+
+	progress := TransferProgress{
+		Path:  m.path,
+		Done:  m.done,
+		Total: m.total,
+	}
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 {
+		progress.Rate = float64(m.done-m.startDone) / elapsed
+	}
+	if m.total >= 0 && progress.Rate > 0 {
+		progress.ETA = time.Duration(float64(m.total-m.done) / progress.Rate * float64(time.Second))
+	}
+	m.progress(progress)
+}
+
+type meteredReader struct {
+	r     io.Reader
+	meter *transferMeter
+}
+
+func (r *meteredReader) Read(buf []byte) (int, error) {
+	if len(buf) > meterChunkSize {
+		buf = buf[:meterChunkSize]
+	}
+	n, err := r.r.Read(buf)
+	if n > 0 {
+		r.meter.add(n)
+		if waitErr := r.meter.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	if err == io.EOF {
+		r.meter.finish()
+	}
+	return n, err
+}
+
+type meteredReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type meteredWriter struct {
+	w     io.WriteCloser
+	meter *transferMeter
+}
+
+func (w *meteredWriter) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		chunk := buf
+		if len(chunk) > meterChunkSize {
+			chunk = chunk[:meterChunkSize]
+		}
+		if err := w.meter.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		w.meter.add(n)
+		if err != nil {
+			return written, err
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+func (w *meteredWriter) Close() error {
+	w.meter.finish()
+	return w.w.Close()
+}
@@ -0,0 +1,89 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	ctx := context.Background()
+
+	start := time.Now()
+	// The first chunk is sent immediately, the second waits for the first.
+	require.NoError(t, limiter.wait(ctx, 100))
+	require.NoError(t, limiter.wait(ctx, 100))
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, limiter.wait(ctx, 100))
+}
+
+func TestMeterReaderProgress(t *testing.T) {
+	var reports []TransferProgress
+	opts := TransferOptions{
+		Progress: func(p TransferProgress) {
+			reports = append(reports, p)
+		},
+	}
+	data := strings.Repeat("x", 3*meterChunkSize)
+
+	reader := opts.meterReader(context.Background(), strings.NewReader(data), "/sdcard/f", 100, int64(len(data))+100)
+	n, err := io.Copy(ioutil.Discard, reader)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+
+	// The first read is reported, then the rest usually come too quickly until the last one.
+	require.True(t, len(reports) >= 2)
+	assert.True(t, reports[0].Done > 100 && reports[0].Done < reports[0].Total)
+	last := reports[len(reports)-1]
+	assert.Equal(t, "/sdcard/f", last.Path)
+	assert.Equal(t, int64(len(data)+100), last.Done)
+	assert.Equal(t, last.Total, last.Done)
+	assert.Equal(t, time.Duration(0), last.ETA)
+}
+
+func TestMeterWriteCloserUnknownSize(t *testing.T) {
+	var reports []TransferProgress
+	opts := TransferOptions{
+		Progress: func(p TransferProgress) {
+			reports = append(reports, p)
+		},
+	}
+	var buf bytes.Buffer
+
+	writer := opts.meterWriteCloser(context.Background(), nopWriteCloser{&buf}, "/sdcard/f")
+	_, err := writer.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	assert.Equal(t, "hello", buf.String())
+	require.NotEmpty(t, reports)
+	last := reports[len(reports)-1]
+	assert.Equal(t, int64(5), last.Done)
+	assert.Equal(t, int64(-1), last.Total)
+}
+
+func TestMeterNotConfigured(t *testing.T) {
+	reader := strings.NewReader("hello")
+	assert.Equal(t, reader, TransferOptions{}.meterReader(context.Background(), reader, "/f", 0, 5))
+}
+
+func TestReaderSize(t *testing.T) {
+	reader := strings.NewReader("hello")
+	reader.Seek(1, io.SeekStart)
+	assert.Equal(t, int64(4), readerSize(reader))
+	rest, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, "ello", string(rest))
+
+	assert.Equal(t, int64(-1), readerSize(&bytes.Buffer{}))
+}
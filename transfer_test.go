@@ -0,0 +1,158 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullResumeOffset(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "partial"))
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("hel")
+	require.NoError(t, err)
+
+	offset, err := pullResumeOffset(file, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+
+	sum := sha256.New()
+	offset, err = pullResumeOffset(file, sum)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	// The rest of the file is written at the end.
+	_, err = io.MultiWriter(file, sum).Write([]byte("lo"))
+	require.NoError(t, err)
+	assert.Equal(t, helloSha256, hex.EncodeToString(sum.Sum(nil)))
+}
+
+func TestPullResumeOffsetNotSeekable(t *testing.T) {
+	_, err := pullResumeOffset(&bytes.Buffer{}, nil)
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestPullTail(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		// The end of the file looks like an exit status until the real one follows.
+		Messages: []string{"lo\n4", "2\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	var dst bytes.Buffer
+	assert.NoError(t, client.pullTail(context.Background(), "/sdcard/f", &dst, 3, 8, TransferOptions{}))
+	assert.Equal(t, "lo\n42", dst.String())
+	assert.Equal(t, `exec:{ tail -c +4 -- /sdcard/f 2>/dev/null; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestPullTailFailure(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"\n1"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	var dst bytes.Buffer
+	err := client.pullTail(context.Background(), "/sdcard/f", &dst, 3, 8, TransferOptions{})
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.Empty(t, dst.String())
+}
+
+func TestPushAttributes(t *testing.T) {
+	perms, mtime, err := PushOptions{}.attributes(&bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0666), perms)
+	assert.Equal(t, MtimeOfClose, mtime)
+
+	perms, mtime, err = PushOptions{Mode: os.ModeDir | 0700, Mtime: someTime}.attributes(&bytes.Buffer{})
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), perms)
+	assert.Equal(t, someTime, mtime)
+
+	_, _, err = PushOptions{Preserve: true}.attributes(&bytes.Buffer{})
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestPushAttributesPreserve(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "script.sh")
+	require.NoError(t, ioutil.WriteFile(localPath, []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Chmod(localPath, 0755))
+	require.NoError(t, os.Chtimes(localPath, someTime, someTime))
+	file, err := os.Open(localPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	perms, mtime, err := PushOptions{Preserve: true}.attributes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), perms)
+	assert.True(t, someTime.Equal(mtime))
+
+	// Explicit attributes take precedence.
+	perms, _, err = PushOptions{Preserve: true, Mode: 0600}.attributes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), perms)
+}
+
+func TestAtomicPushPath(t *testing.T) {
+	tempPath := atomicPushPath("/sdcard/dir/app.apk")
+	assert.True(t, strings.HasPrefix(tempPath, "/sdcard/dir/.app.apk."), tempPath)
+	assert.True(t, strings.HasSuffix(tempPath, ".tmp"), tempPath)
+}
+
+func TestPushAtomicResume(t *testing.T) {
+	client := (&Adb{&MockServer{}}).Device(AnyDevice())
+	err := client.PushWithOptions(context.Background(), strings.NewReader("hello"), "/sdcard/f",
+		PushOptions{Atomic: true, Resume: true})
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestPushDeviceWriteFailure(t *testing.T) {
+	s := pipeServer(func(conn net.Conn) {
+		// Transport request, then sync request.
+		if !acceptRequests(conn, 2, make(chan string, 2)) {
+			return
+		}
+		scanner := wire.NewSyncScanner(conn)
+		id := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, id); err != nil {
+				return
+			}
+			if string(id) == wire.StatusSyncDone {
+				break
+			}
+			// SEND and DATA are followed by a length and data.
+			if _, err := scanner.ReadString(); err != nil {
+				return
+			}
+		}
+		// DONE is followed by the modification time.
+		if _, err := scanner.ReadTime(); err != nil {
+			return
+		}
+
+		sender := wire.NewSyncSender(conn)
+		sender.SendOctetString(wire.StatusFailure)
+		sender.SendBytes([]byte("couldn't create file: No space left on device"))
+	})
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	// Skip asking for the features, which needs another connection.
+	client.cachedFeatures = FeatureSet{}
+
+	err := client.PushWithOptions(context.Background(), strings.NewReader("hello"), "/sdcard/f", PushOptions{})
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.Contains(t, ErrorWithCauseChain(err), "No space left on device")
+}
@@ -0,0 +1,93 @@
+package adb
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// Verification selects how pulls and pushes are checked end to end: the data is hashed on the
+// host while it's transferred, and compared with a hash computed on the device afterwards.
+type Verification int
+
+const (
+	// VerifyNone doesn't check transferred files.
+	VerifyNone Verification = iota
+	// VerifySHA256 compares SHA-256 hashes, computed on the device with sha256sum.
+	VerifySHA256
+	// VerifyMD5 compares MD5 hashes, computed on the device with md5sum. It's faster than
+	// SHA-256 on older devices, and is enough to detect corruption.
+	VerifyMD5
+)
+
+func (v Verification) String() string {
+	switch v {
+	case VerifyNone:
+		return "none"
+	case VerifySHA256:
+		return "sha256"
+	case VerifyMD5:
+		return "md5"
+	}
+	return fmt.Sprintf("Verification(%d)", int(v))
+}
+
+// newHash returns the hash to compute on the host, or nil for VerifyNone.
+func (v Verification) newHash() (hash.Hash, error) {
+	switch v {
+	case VerifyNone:
+		return nil, nil
+	case VerifySHA256:
+		return sha256.New(), nil
+	case VerifyMD5:
+		return md5.New(), nil
+	}
+	return nil, errors.AssertionErrorf("invalid verification: %s", v)
+}
+
+// command returns the device command that prints the same hash as newHash.
+func (v Verification) command() string {
+	if v == VerifyMD5 {
+		return "md5sum"
+	}
+	return "sha256sum"
+}
+
+// verifyChecksum checks that the file at path on the device has the hash computed by sum.
+func (c *Device) verifyChecksum(ctx context.Context, path string, v Verification, sum hash.Hash) error {
+	remoteSum, err := c.remoteChecksum(ctx, path, v, -1)
+	if err != nil {
+		return err
+	}
+	if localSum := hex.EncodeToString(sum.Sum(nil)); localSum != remoteSum {
+		return errors.Errorf(errors.AdbError, "%s: %s checksum mismatch: %s on the host, %s on the device",
+			path, v, localSum, remoteSum)
+	}
+	return nil
+}
+
+// remoteChecksum hashes the first size bytes of the file at path on the device, or the whole file
+// if size is negative, and returns the hash in hex.
+func (c *Device) remoteChecksum(ctx context.Context, path string, v Verification, size int64) (string, error) {
+	commandLine := shellCommandLine(v.command(), "--", path)
+	if size >= 0 {
+		commandLine = fmt.Sprintf("%s | %s", shellCommandLine("head", "-c", fmt.Sprint(size), "--", path), v.command())
+	}
+	output, err := c.runFileCommand(ctx, path, commandLine)
+	if err != nil {
+		return "", err
+	}
+
+	sum := strings.ToLower(strings.SplitN(strings.TrimSpace(output), " ", 2)[0])
+	expected, _ := v.newHash()
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != expected.Size() {
+		return "", errors.Errorf(errors.ParseError, "invalid %s output: %q", v.command(), output)
+	}
+	return sum, nil
+}
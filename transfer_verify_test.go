@@ -0,0 +1,62 @@
+package adb
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteChecksum(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{helloSha256 + "  -\n", "\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	sum, err := client.remoteChecksum(context.Background(), "/sdcard/a b", VerifySHA256, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, helloSha256, sum)
+	assert.Equal(t, `exec:{ head -c 5 -- '/sdcard/a b' | sha256sum; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestRemoteChecksumInvalid(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"/system/bin/sh: md5sum: not found\n", "\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	_, err := client.remoteChecksum(context.Background(), "/sdcard/f", VerifyMD5, -1)
+	assert.True(t, HasErrCode(err, ParseError))
+	assert.Equal(t, `exec:{ md5sum -- /sdcard/f; } 2>&1; printf '\n%d' $?`, s.Requests[1])
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{helloSha256 + "  /sdcard/f\n", "\n0"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	sum := sha256.New()
+	sum.Write([]byte("hellO"))
+	err := client.verifyChecksum(context.Background(), "/sdcard/f", VerifySHA256, sum)
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.Contains(t, err.Error(), "sha256 checksum mismatch")
+}
+
+func TestVerificationNewHash(t *testing.T) {
+	sum, err := VerifyNone.newHash()
+	assert.NoError(t, err)
+	assert.Nil(t, sum)
+
+	sum, err = VerifyMD5.newHash()
+	assert.NoError(t, err)
+	assert.Equal(t, 16, sum.Size())
+
+	_, err = Verification(42).newHash()
+	assert.True(t, HasErrCode(err, AssertionError))
+}
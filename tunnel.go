@@ -0,0 +1,202 @@
+package adb
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+	"github.com/zach-klippenstein/goadb/wire"
+)
+
+/*
+DialService opens a connection to a service on the device, e.g. "tcp:8080" or "localabstract:foo",
+and returns it as a net.Conn.
+
+Each connection goes through its own transport connection to the device, so unlike Forward this
+doesn't add anything to the adb server's forward table, and any number of connections can be open
+at once.
+
+The connection is closed when ctx is done.
+*/
+func (c *Device) DialService(ctx context.Context, service string) (net.Conn, error) {
+	conn, err := c.openService(ctx, service)
+	if err != nil {
+		return nil, wrapClientError(err, c, "DialService(%s)", service)
+	}
+
+	local, remote := net.Pipe()
+	go joinConns(remote, conn)
+
+	return serviceConn{
+		Conn:       local,
+		remoteAddr: serviceAddr(service),
+	}, nil
+}
+
+// TunnelOptions configures ServeForwardWithOptions and ListenForwardWithOptions. The zero value is
+// valid.
+type TunnelOptions struct {
+	// OnError is called when a connection can't be tunneled to the device, e.g. because the
+	// device is offline or unauthorized, or nothing is listening on the remote spec. The local
+	// connection is closed. If nil, the error is logged with log.Printf.
+	OnError func(err error)
+}
+
+/*
+ServeForward accepts connections from listener and tunnels each one to remote on the device, as if
+it had been set up with Forward, but without involving the adb server's forward table.
+
+Blocks until listener fails or ctx is done, and closes listener before returning.
+Connections that are still open are closed when ctx is done.
+*/
+func (c *Device) ServeForward(ctx context.Context, listener net.Listener, remote ForwardSpec) error {
+	return c.ServeForwardWithOptions(ctx, listener, remote, TunnelOptions{})
+}
+
+// ServeForwardWithOptions is like ServeForward, but takes options.
+func (c *Device) ServeForwardWithOptions(ctx context.Context, listener net.Listener, remote ForwardSpec, opts TunnelOptions) error {
+	if opts.OnError == nil {
+		opts.OnError = func(err error) {
+			log.Printf("[ServeForward] %v", err)
+		}
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+	defer listener.Close()
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return wrapClientError(
+				errors.WrapErrorf(err, errors.NetworkError, "error accepting connection"),
+				c, "ServeForward(%s)", remote)
+		}
+
+		go func() {
+			conn, err := c.openService(ctx, remote.String())
+			if err != nil {
+				local.Close()
+				if ctx.Err() == nil {
+					opts.OnError(wrapClientError(err, c, "ServeForward(%s)", remote))
+				}
+				return
+			}
+			joinConns(local, conn)
+		}()
+	}
+}
+
+/*
+ListenForward listens on localAddr, a TCP address on the host, and serves connections with
+ServeForward in the background. Use port 0 to let the system pick a free port, and get it from
+the returned listener's Addr.
+
+Stops when ctx is done or the returned listener is closed.
+*/
+func (c *Device) ListenForward(ctx context.Context, localAddr string, remote ForwardSpec) (net.Listener, error) {
+	return c.ListenForwardWithOptions(ctx, localAddr, remote, TunnelOptions{})
+}
+
+// ListenForwardWithOptions is like ListenForward, but takes options.
+func (c *Device) ListenForwardWithOptions(ctx context.Context, localAddr string, remote ForwardSpec, opts TunnelOptions) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", localAddr)
+	if err != nil {
+		return nil, wrapClientError(
+			errors.WrapErrorf(err, errors.NetworkError, "error listening on %s", localAddr),
+			c, "ListenForward(%s, %s)", localAddr, remote)
+	}
+
+	go c.ServeForwardWithOptions(ctx, listener, remote, opts)
+	return listener, nil
+}
+
+// openService connects to service on the device, and returns the connection once the device
+// has accepted it. The connection carries raw service data from then on.
+func (c *Device) openService(ctx context.Context, service string) (*wire.Conn, error) {
+	conn, err := c.dialDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wire.SendMessageString(conn, service); err != nil {
+		conn.Close()
+		c.forgetFeaturesAfter(err)
+		return nil, err
+	}
+	if _, err = conn.ReadStatus(service); err != nil {
+		conn.Close()
+		c.forgetFeaturesAfter(err)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// joinConns copies data in both directions between a and b. When one side reaches EOF, the other
+// is half-closed, so e.g. a client that shuts down writing after its request still gets the reply.
+// Both are closed once both copies have finished, or either one fails.
+func joinConns(a, b io.ReadWriteCloser) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			a.Close()
+			b.Close()
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		copyHalf(a, b, closeBoth)
+		close(done)
+	}()
+	copyHalf(b, a, closeBoth)
+	<-done
+	closeBoth()
+}
+
+// copyHalf copies from src to dst until src reaches EOF, then half-closes dst. EOF only means the
+// peer is done writing if src can be half-closed; otherwise, or if the copy or half-close fails, it
+// closes both sides to stop the other copy too.
+func copyHalf(dst io.Writer, src io.Reader, closeBoth func()) {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+
+	_, err := io.Copy(dst, src)
+	_, srcHalfCloses := src.(closeWriter)
+	if closer, ok := dst.(closeWriter); ok && err == nil && srcHalfCloses && closer.CloseWrite() == nil {
+		return
+	}
+	closeBoth()
+}
+
+// serviceConn reports the device service it's connected to as its remote address.
+type serviceConn struct {
+	net.Conn
+	remoteAddr serviceAddr
+}
+
+func (c serviceConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// serviceAddr is the name of a service on a device, e.g. "tcp:8080".
+type serviceAddr string
+
+func (serviceAddr) Network() string {
+	return "adb"
+}
+
+func (a serviceAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,161 @@
+package adb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/drtechco/goadb/internal/errors"
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoServer returns a server that accepts transport and service requests, then echoes raw
+// data back on each connection. The requests are sent to the returned channel.
+func newEchoServer() (pipeServer, <-chan string) {
+	requests := make(chan string, 16)
+	return func(conn net.Conn) {
+		if acceptRequests(conn, 2, requests) {
+			io.Copy(conn, conn)
+		}
+	}, requests
+}
+
+func TestDialService(t *testing.T) {
+	s, requests := newEchoServer()
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	conn, err := client.DialService(context.Background(), "tcp:8080")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "host:transport:abc", <-requests)
+	assert.Equal(t, "tcp:8080", <-requests)
+	assert.Equal(t, "adb", conn.RemoteAddr().Network())
+	assert.Equal(t, "tcp:8080", conn.RemoteAddr().String())
+
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+}
+
+func TestDialServiceRejected(t *testing.T) {
+	s := &MockServer{
+		Status: wire.StatusSuccess,
+		Errs:   []error{nil, nil, nil, nil, errors.Errorf(errors.AdbError, "closed")},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	_, err := client.DialService(context.Background(), "tcp:8080")
+	assert.True(t, HasErrCode(err, AdbError))
+	assert.Equal(t, []string{"Dial", "SendMessage", "ReadStatus", "SendMessage", "ReadStatus", "Close", "Close"}, s.Trace)
+}
+
+func TestListenForward(t *testing.T) {
+	s, requests := newEchoServer()
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := client.ListenForward(ctx, "127.0.0.1:0", TCPForwardSpec(8080))
+	require.NoError(t, err)
+
+	// Each connection gets its own tunnel.
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+
+		_, err = io.WriteString(conn, "hello\n")
+		require.NoError(t, err)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "hello\n", line)
+		conn.Close()
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "host:transport:abc", <-requests)
+		assert.Equal(t, "tcp:8080", <-requests)
+	}
+}
+
+func TestServeForwardStopsOnCancel(t *testing.T) {
+	s, _ := newEchoServer()
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- client.ServeForward(ctx, listener, TCPForwardSpec(8080))
+	}()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestServeForwardHalfClose(t *testing.T) {
+	s := newTCPServer(t, func(conn net.Conn) {
+		if acceptRequests(conn, 2, make(chan string, 2)) {
+			// Only reply once the client has finished its request.
+			input, _ := ioutil.ReadAll(conn)
+			conn.Write(bytes.ToUpper(input))
+		}
+	})
+
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listener, err := client.ListenForward(ctx, "127.0.0.1:0", TCPForwardSpec(8080))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	reply, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "HELLO\n", string(reply))
+}
+
+func TestServeForwardReportsErrors(t *testing.T) {
+	// Nothing is listening on the server's address once this is closed.
+	serverListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverListener.Close()
+
+	client := (&Adb{tcpServer{serverListener.Addr().String()}}).Device(DeviceWithSerial("abc"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	listener, err := client.ListenForwardWithOptions(ctx, "127.0.0.1:0", TCPForwardSpec(8080), TunnelOptions{
+		OnError: func(err error) {
+			errs <- err
+		},
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = <-errs
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ServeForward(tcp:8080)")
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
@@ -11,6 +11,9 @@ import (
 
 var (
 	whitespaceRegex = regexp.MustCompile(`^\s*$`)
+
+	// Characters that never need to be quoted in a shell word.
+	shellSafeRegex = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
 )
 
 func containsWhitespace(str string) bool {
@@ -21,6 +24,25 @@ func isBlank(str string) bool {
 	return whitespaceRegex.MatchString(str)
 }
 
+// shellQuote quotes str so the device's shell treats it as a single word, without expanding
+// anything inside it.
+func shellQuote(str string) string {
+	if shellSafeRegex.MatchString(str) {
+		return str
+	}
+	return "'" + strings.Replace(str, "'", `'\''`, -1) + "'"
+}
+
+// shellCommandLine returns the command line to run cmd with args, quoting each argument with
+// shellQuote. cmd itself isn't quoted, so it can contain its own arguments.
+func shellCommandLine(cmd string, args ...string) string {
+	words := []string{cmd}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
 func wrapClientError(err error, client interface{}, operation string, args ...interface{}) error {
 	if err == nil {
 		return nil
@@ -25,3 +25,15 @@ func TestIsBlankWhenJustWhitespace(t *testing.T) {
 func TestIsBlankNo(t *testing.T) {
 	assert.False(t, isBlank("     h   "))
 }
+
+func TestShellQuoteSafe(t *testing.T) {
+	assert.Equal(t, "com.example.app", shellQuote("com.example.app"))
+	assert.Equal(t, "/data/local/tmp/a-b_c.apk", shellQuote("/data/local/tmp/a-b_c.apk"))
+}
+
+func TestShellQuoteUnsafe(t *testing.T) {
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, "'hello world'", shellQuote("hello world"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	assert.Equal(t, "'$HOME;rm'", shellQuote("$HOME;rm"))
+}
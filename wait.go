@@ -0,0 +1,121 @@
+package adb
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// bootPollInterval is how often WaitForBootCompleted checks whether the device has booted.
+const bootPollInterval = time.Second
+
+/*
+WaitFor blocks until a device matching descriptor is in state, or ctx is done. state may be
+StateOnline, StateRecovery, StateRescue, StateSideload, StateBootloader, or StateDisconnected to
+wait for the device to go away.
+
+Corresponds to the command:
+
+	adb wait-for-<transport>-<state>
+*/
+func (c *Adb) WaitFor(ctx context.Context, descriptor DeviceDescriptor, state DeviceState) error {
+	return c.Device(descriptor).waitFor(ctx, state)
+}
+
+func (c *Device) waitFor(ctx context.Context, state DeviceState) error {
+	req, err := waitForRequest(c.descriptor, state)
+	if err != nil {
+		return wrapClientError(err, c, "WaitFor")
+	}
+
+	conn, err := c.server.Dial(ctx)
+	if err != nil {
+		return wrapClientError(err, c, "WaitFor")
+	}
+	defer conn.Close()
+
+	if err = conn.SendMessage([]byte(req)); err != nil {
+		return c.waitForError(ctx, err)
+	}
+	// The server replies once when it accepts the request, and again when the device is in state.
+	if _, err = conn.ReadStatus(req); err != nil {
+		return c.waitForError(ctx, err)
+	}
+	if _, err = conn.ReadStatus(req); err != nil {
+		return c.waitForError(ctx, err)
+	}
+	return nil
+}
+
+// waitForError returns ctx's error if it's done, since that's why the connection failed.
+func (c *Device) waitForError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return wrapClientError(err, c, "WaitFor")
+}
+
+// waitForRequest returns the host request that waits for a device matching descriptor to be in
+// state.
+func waitForRequest(descriptor DeviceDescriptor, state DeviceState) (string, error) {
+	transport := "any"
+	switch descriptor.descriptorType {
+	case DeviceUsb:
+		transport = "usb"
+	case DeviceLocal:
+		transport = "local"
+	}
+
+	var stateName string
+	switch state {
+	case StateOnline:
+		stateName = "device"
+	case StateRecovery:
+		stateName = "recovery"
+	case StateRescue:
+		stateName = "rescue"
+	case StateSideload:
+		stateName = "sideload"
+	case StateBootloader:
+		stateName = "bootloader"
+	case StateDisconnected:
+		stateName = "disconnect"
+	default:
+		return "", errors.AssertionErrorf("can't wait for device state %s", state)
+	}
+	return descriptor.getHostPrefix() + ":wait-for-" + transport + "-" + stateName, nil
+}
+
+/*
+WaitForBootCompleted blocks until the device is online and has finished booting, or ctx is done.
+Waiting for the device to come online isn't enough before e.g. installing packages, since the
+package manager isn't running until boot has completed.
+
+Corresponds to the commands:
+
+	adb wait-for-device
+	adb shell getprop sys.boot_completed
+*/
+func (c *Device) WaitForBootCompleted(ctx context.Context) error {
+	for {
+		if err := c.waitFor(ctx, StateOnline); err != nil {
+			return err
+		}
+
+		// The device may go offline again while booting, so errors just mean it isn't ready yet.
+		completed, err := c.RunCommandContext(ctx, "getprop", "sys.boot_completed")
+		if err == nil && strings.TrimSpace(completed) == "1" {
+			return nil
+		}
+
+		timer := time.NewTimer(bootPollInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
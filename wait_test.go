@@ -0,0 +1,52 @@
+package adb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drtechco/goadb/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForRequest(t *testing.T) {
+	for _, test := range []struct {
+		descriptor DeviceDescriptor
+		state      DeviceState
+		req        string
+	}{
+		{AnyDevice(), StateOnline, "host:wait-for-any-device"},
+		{AnyUsbDevice(), StateRecovery, "host-usb:wait-for-usb-recovery"},
+		{AnyLocalDevice(), StateDisconnected, "host-local:wait-for-local-disconnect"},
+		{DeviceWithSerial("abc"), StateOnline, "host-serial:abc:wait-for-any-device"},
+		{DeviceWithTransportID(3), StateBootloader, "host-transport-id:3:wait-for-any-bootloader"},
+	} {
+		req, err := waitForRequest(test.descriptor, test.state)
+		assert.NoError(t, err)
+		assert.Equal(t, test.req, req)
+	}
+
+	_, err := waitForRequest(AnyDevice(), StateUnauthorized)
+	assert.True(t, HasErrCode(err, AssertionError))
+}
+
+func TestWaitFor(t *testing.T) {
+	s := &MockServer{Status: wire.StatusSuccess}
+
+	assert.NoError(t, (&Adb{s}).WaitFor(context.Background(), DeviceWithSerial("abc"), StateSideload))
+	assert.Equal(t, []string{"host-serial:abc:wait-for-any-sideload"}, s.Requests)
+}
+
+func TestWaitForBootCompleted(t *testing.T) {
+	s := &MockServer{
+		Status:   wire.StatusSuccess,
+		Messages: []string{"1\n"},
+	}
+	client := (&Adb{s}).Device(DeviceWithSerial("abc"))
+
+	assert.NoError(t, client.WaitForBootCompleted(context.Background()))
+	assert.Equal(t, []string{
+		"host-serial:abc:wait-for-any-device",
+		"host:transport:abc",
+		"shell:getprop sys.boot_completed",
+	}, s.Requests)
+}
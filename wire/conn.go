@@ -4,9 +4,13 @@ import (
 )
 
 const (
-	// The official implementation of adb imposes an undocumented 255-byte limit
-	// on messages.
-	MaxMessageLength = 255
+	// MaxMessageLength is the longest message that can be read, since lengths are sent as 4 hex
+	// digits. Responses like host:devices-l can be this long when many devices are connected.
+	MaxMessageLength = 0xffff
+
+	// MaxRequestLength is the longest request the adb server accepts. The official
+	// implementation rejects requests longer than MAX_PAYLOAD_V1.
+	MaxRequestLength = 4096
 )
 
 /*
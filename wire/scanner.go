@@ -37,11 +37,16 @@ Scanner reads tokens from a server.
 See Conn for more details.
 */
 type Scanner interface {
+	// Read reads raw bytes from the connection, for services that stop using the message format
+	// once they're opened.
+	io.Reader
 	io.Closer
 	StatusReader
 	ReadMessage() ([]byte, error)
 	ReadUntilEof() ([]byte, error)
-	ReadUntilEofV2WithStd(stdout  io.Writer, stderr  io.Writer) (int,error)
+	// ReadUntilEofV2WithStd decodes shell protocol packets until the stream is closed, writing
+	// stdout and stderr packets to the respective writers, and returns the exit code.
+	ReadUntilEofV2WithStd(stdout io.Writer, stderr io.Writer) (int, error)
 	NewSyncScanner() SyncScanner
 }
 
@@ -61,6 +66,10 @@ func ReadMessageString(s Scanner) (string, error) {
 	return string(msg), nil
 }
 
+func (s *realScanner) Read(buf []byte) (int, error) {
+	return s.reader.Read(buf)
+}
+
 func (s *realScanner) ReadStatus(req string) (string, error) {
 	return readStatusFailureAsError(s.reader, req, readHexLength)
 }
@@ -77,16 +86,10 @@ func (s *realScanner) ReadUntilEof() ([]byte, error) {
 	return data, nil
 }
 
-func (s *realScanner) ReadUntilEofV2WithStd(stdout  io.Writer, stderr  io.Writer) (int,error) {
-	exitCode, err :=DecodeDataFromReader(s.reader, stdout, stderr)
-	if err != nil {
-		return exitCode, err
-	}
-	return exitCode, nil
+func (s *realScanner) ReadUntilEofV2WithStd(stdout io.Writer, stderr io.Writer) (int, error) {
+	return NewShellV2Reader(s.reader).CopyOutput(stdout, stderr)
 }
 
-
-
 func (s *realScanner) NewSyncScanner() SyncScanner {
 	return NewSyncScanner(s.reader)
 }
@@ -173,11 +176,6 @@ func readHexLength(r io.Reader) (int, error) {
 		return 0, errors.WrapErrorf(err, errors.NetworkError, "could not parse hex length %v", lengthHex)
 	}
 
-	// Clip the length to 255, as per the Google implementation.
-	if length > MaxMessageLength {
-		length = MaxMessageLength
-	}
-
 	return int(length), nil
 }
 
@@ -3,8 +3,10 @@ package wire
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/drtechco/goadb/internal/errors"
@@ -78,6 +80,25 @@ func TestReadLongerMessage(t *testing.T) {
 	assertEof(t, s)
 }
 
+func TestReadMessageLongerThan255Bytes(t *testing.T) {
+	var devices []string
+	for i := 0; i < 100; i++ {
+		devices = append(devices, fmt.Sprintf("emulator-%d\tdevice\n", 5554+2*i))
+	}
+	list := strings.Join(devices, "")
+	s := newEofReader(fmt.Sprintf("%04x%s0005hello", len(list), list))
+
+	msg, err := readMessage(s, readHexLength)
+	assert.NoError(t, err)
+	assert.Equal(t, list, string(msg))
+
+	// The rest of the stream is still framed correctly.
+	msg, err = readMessage(s, readHexLength)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(msg))
+	assertEof(t, s)
+}
+
 func TestReadEmptyMessage(t *testing.T) {
 	s := newEofReader("0000")
 	msg, err := readMessage(s, readHexLength)
@@ -103,6 +124,13 @@ func TestReadLength(t *testing.T) {
 	assertEof(t, s)
 }
 
+func TestReadMaxLength(t *testing.T) {
+	s := newEofReader("ffff")
+	l, err := readHexLength(s)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxMessageLength, l)
+}
+
 func TestReadLengthIncompleteLength(t *testing.T) {
 	s := newEofReader("aaa")
 	_, err := readHexLength(s)
@@ -9,10 +9,22 @@ import (
 
 // Sender sends messages to the server.
 type Sender interface {
+	// Write writes raw bytes to the connection, for services that stop using the message format
+	// once they're opened.
+	io.Writer
+
 	SendMessage(msg []byte) error
 
 	NewSyncSender() SyncSender
 
+	// NewShellV2Writer returns a writer that encodes shell protocol packets.
+	// The connection must already have been switched to a "shell,v2" service.
+	NewShellV2Writer() *ShellV2Writer
+
+	// CloseWrite shuts down the writing side of the connection, if it supports it, so the service
+	// on the other end sees the end of its input while the connection can still be read from.
+	CloseWrite() error
+
 	Close() error
 }
 
@@ -29,7 +41,7 @@ func SendMessageString(s Sender, msg string) error {
 }
 
 func (s *realSender) SendMessage(msg []byte) error {
-	if len(msg) > MaxMessageLength {
+	if len(msg) > MaxRequestLength {
 		return errors.AssertionErrorf("message length exceeds maximum: %d", len(msg))
 	}
 
@@ -37,10 +49,25 @@ func (s *realSender) SendMessage(msg []byte) error {
 	return writeFully(s.writer, []byte(lengthAndMsg))
 }
 
+func (s *realSender) Write(data []byte) (int, error) {
+	return s.writer.Write(data)
+}
+
+func (s *realSender) CloseWrite() error {
+	if closer, ok := s.writer.(closeWriter); ok {
+		return errors.WrapErrorf(closer.CloseWrite(), errors.NetworkError, "error closing sender for writing")
+	}
+	return errors.Errorf(errors.AssertionError, "connection doesn't support closing for writing")
+}
+
 func (s *realSender) NewSyncSender() SyncSender {
 	return NewSyncSender(s.writer)
 }
 
+func (s *realSender) NewShellV2Writer() *ShellV2Writer {
+	return NewShellV2Writer(s.writer)
+}
+
 func (s *realSender) Close() error {
 	return errors.WrapErrorf(s.writer.Close(), errors.NetworkError, "error closing sender")
 }
@@ -2,8 +2,10 @@ package wire
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
+	"github.com/drtechco/goadb/internal/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,6 +23,21 @@ func TestWriteEmptyMessage(t *testing.T) {
 	assert.Equal(t, "0000", b.String())
 }
 
+func TestWriteLongMessage(t *testing.T) {
+	s, b := NewTestSender()
+	msg := strings.Repeat("x", 300)
+	err := SendMessageString(s, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "012c"+msg, b.String())
+}
+
+func TestWriteMessageTooLong(t *testing.T) {
+	s, b := NewTestSender()
+	err := SendMessageString(s, strings.Repeat("x", MaxRequestLength+1))
+	assert.True(t, errors.HasErrCode(err, errors.AssertionError))
+	assert.Equal(t, 0, b.Len())
+}
+
 func NewTestSender() (Sender, *TestWriter) {
 	w := new(TestWriter)
 	return NewSender(w), w
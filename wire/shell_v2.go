@@ -0,0 +1,201 @@
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/zach-klippenstein/goadb/internal/errors"
+)
+
+// ShellV2PacketID identifies the type of a shell protocol packet.
+// Values are taken from adb/shell_protocol.h.
+type ShellV2PacketID byte
+
+const (
+	ShellV2Stdin ShellV2PacketID = iota
+	ShellV2Stdout
+	ShellV2Stderr
+	ShellV2Exit
+	ShellV2CloseStdin
+	ShellV2WindowSizeChange
+)
+
+const (
+	// Each packet starts with a 1-byte ID followed by a little-endian 32-bit data length.
+	shellV2HeaderSize = 5
+
+	// ShellV2MaxDataSize is the largest payload sent in a single packet.
+	// Older versions of adbd only accept packets that fit in a 4k buffer, header included.
+	ShellV2MaxDataSize = 4096 - shellV2HeaderSize
+
+	// adbd never sends packets larger than its max payload size (1M), so anything larger means
+	// the stream is corrupt. Checked before allocating the data buffer.
+	shellV2MaxReadDataSize = 1024 * 1024
+)
+
+// ShellV2Packet is a single packet read from a "shell,v2" stream.
+type ShellV2Packet struct {
+	ID   ShellV2PacketID
+	Data []byte
+}
+
+// ExitCode returns the exit code carried by a ShellV2Exit packet.
+func (p ShellV2Packet) ExitCode() int {
+	if len(p.Data) == 0 {
+		return 0
+	}
+	return int(p.Data[0])
+}
+
+/*
+ShellV2Writer encodes packets for the "shell,v2" protocol.
+
+Every packet on the wire looks like:
+
+	[1-byte ID][4-byte little-endian length][length bytes of data]
+
+It is safe to call methods on a ShellV2Writer from multiple goroutines, e.g. to send window size
+changes while another goroutine is writing stdin.
+*/
+type ShellV2Writer struct {
+	writer io.Writer
+
+	// Serializes writes so packets from different goroutines are never interleaved.
+	lock sync.Mutex
+}
+
+func NewShellV2Writer(w io.Writer) *ShellV2Writer {
+	return &ShellV2Writer{writer: w}
+}
+
+// WritePacket writes a single packet with the given ID and data.
+// Returns an assertion error if data is larger than ShellV2MaxDataSize.
+func (w *ShellV2Writer) WritePacket(id ShellV2PacketID, data []byte) error {
+	if len(data) > ShellV2MaxDataSize {
+		return errors.AssertionErrorf("shell packet data must be <= %d in length", ShellV2MaxDataSize)
+	}
+
+	packet := make([]byte, shellV2HeaderSize+len(data))
+	packet[0] = byte(id)
+	binary.LittleEndian.PutUint32(packet[1:shellV2HeaderSize], uint32(len(data)))
+	copy(packet[shellV2HeaderSize:], data)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return writeFully(w.writer, packet)
+}
+
+// WriteStdin sends data to the remote process's stdin, split into as many packets as necessary.
+func (w *ShellV2Writer) WriteStdin(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		partialData := data
+		if len(partialData) > ShellV2MaxDataSize {
+			partialData = partialData[:ShellV2MaxDataSize]
+		}
+
+		if err := w.WritePacket(ShellV2Stdin, partialData); err != nil {
+			return n, err
+		}
+
+		n += len(partialData)
+		data = data[len(partialData):]
+	}
+	return n, nil
+}
+
+// CloseStdin tells the device to close the remote process's stdin.
+func (w *ShellV2Writer) CloseStdin() error {
+	return w.WritePacket(ShellV2CloseStdin, nil)
+}
+
+// WriteWindowSize tells the device the terminal size changed.
+// The payload is formatted as "ROWSxCOLS,XPIXELSxYPIXELS" and NUL-terminated, as adbd parses it
+// with sscanf.
+func (w *ShellV2Writer) WriteWindowSize(rows, cols, xPixels, yPixels int) error {
+	data := fmt.Sprintf("%dx%d,%dx%d\x00", rows, cols, xPixels, yPixels)
+	return w.WritePacket(ShellV2WindowSizeChange, []byte(data))
+}
+
+/*
+ShellV2Reader decodes packets from a "shell,v2" stream.
+
+Packets are read with io.ReadFull, so a header or payload split across any number of reads from
+the underlying connection is reassembled before being returned.
+*/
+type ShellV2Reader struct {
+	reader io.Reader
+	header [shellV2HeaderSize]byte
+}
+
+func NewShellV2Reader(r io.Reader) *ShellV2Reader {
+	return &ShellV2Reader{reader: r}
+}
+
+// ReadPacket reads the next packet from the stream.
+// Returns io.EOF if the stream ends cleanly between two packets, and a ConnectionResetError
+// if it ends in the middle of one.
+func (r *ShellV2Reader) ReadPacket() (ShellV2Packet, error) {
+	n, err := io.ReadFull(r.reader, r.header[:])
+	if err == io.EOF {
+		return ShellV2Packet{}, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return ShellV2Packet{}, errIncompleteMessage("shell packet header", n, shellV2HeaderSize)
+	} else if err != nil {
+		return ShellV2Packet{}, errors.WrapErrorf(err, errors.NetworkError, "error reading shell packet header")
+	}
+
+	id := ShellV2PacketID(r.header[0])
+	length := binary.LittleEndian.Uint32(r.header[1:])
+	if length > shellV2MaxReadDataSize {
+		return ShellV2Packet{}, errors.Errorf(errors.ParseError,
+			"shell packet %d too large: %d bytes", id, length)
+	}
+
+	data := make([]byte, length)
+	n, err = io.ReadFull(r.reader, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ShellV2Packet{}, errIncompleteMessage("shell packet data", n, int(length))
+	} else if err != nil {
+		return ShellV2Packet{}, errors.WrapErrorf(err, errors.NetworkError, "error reading shell packet data")
+	}
+
+	return ShellV2Packet{ID: id, Data: data}, nil
+}
+
+// CopyOutput reads packets until the end of the stream, writing stdout and stderr packets to
+// the respective writers, and returns the exit code sent by the device.
+// Either writer may be nil to discard that stream.
+func (r *ShellV2Reader) CopyOutput(stdout, stderr io.Writer) (int, error) {
+	var exitCode int
+	for {
+		packet, err := r.ReadPacket()
+		if err == io.EOF {
+			return exitCode, nil
+		} else if err != nil {
+			return exitCode, err
+		}
+
+		switch packet.ID {
+		case ShellV2Stdout:
+			if stdout != nil {
+				if _, err := stdout.Write(packet.Data); err != nil {
+					return exitCode, errors.WrapErrorf(err, errors.NetworkError, "error writing to stdout")
+				}
+			}
+		case ShellV2Stderr:
+			if stderr != nil {
+				if _, err := stderr.Write(packet.Data); err != nil {
+					return exitCode, errors.WrapErrorf(err, errors.NetworkError, "error writing to stderr")
+				}
+			}
+		case ShellV2Exit:
+			exitCode = packet.ExitCode()
+		case ShellV2WindowSizeChange:
+			// Only meaningful in the other direction, ignore.
+		default:
+			return exitCode, errors.Errorf(errors.ParseError, "unknown shell packet ID: %d", packet.ID)
+		}
+	}
+}
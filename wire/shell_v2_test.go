@@ -0,0 +1,183 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/drtechco/goadb/internal/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellV2WritePacket(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewShellV2Writer(&buf)
+	err := w.WritePacket(ShellV2Stdout, []byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\001\005\000\000\000hello", buf.String())
+}
+
+func TestShellV2WritePacketTooLong(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewShellV2Writer(&buf)
+	err := w.WritePacket(ShellV2Stdin, make([]byte, ShellV2MaxDataSize+1))
+	assert.Equal(t, errors.AssertionErrorf("shell packet data must be <= %d in length", ShellV2MaxDataSize), err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestShellV2WriteStdinSplitsPackets(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewShellV2Writer(&buf)
+	data := bytes.Repeat([]byte("a"), ShellV2MaxDataSize+1)
+
+	n, err := w.WriteStdin(data)
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, 2*shellV2HeaderSize+len(data), buf.Len())
+	assert.Equal(t, []byte{0, 0xfb, 0x0f, 0, 0}, buf.Bytes()[:shellV2HeaderSize])
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 'a'}, buf.Bytes()[shellV2HeaderSize+ShellV2MaxDataSize:])
+}
+
+func TestShellV2CloseStdin(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewShellV2Writer(&buf)
+	assert.NoError(t, w.CloseStdin())
+	assert.Equal(t, "\004\000\000\000\000", buf.String())
+}
+
+func TestShellV2WriteWindowSize(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewShellV2Writer(&buf)
+	assert.NoError(t, w.WriteWindowSize(24, 80, 0, 0))
+	assert.Equal(t, "\005\012\000\000\00024x80,0x0\000", buf.String())
+}
+
+func TestShellV2ReadPacket(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader("\001\005\000\000\000hello\003\001\000\000\000\002"))
+
+	packet, err := r.ReadPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, ShellV2Packet{ShellV2Stdout, []byte("hello")}, packet)
+
+	packet, err = r.ReadPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, ShellV2Exit, packet.ID)
+	assert.Equal(t, 2, packet.ExitCode())
+
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestShellV2ReadPacketSplitAcrossReads(t *testing.T) {
+	r := NewShellV2Reader(iotest.OneByteReader(strings.NewReader("\002\005\000\000\000hello")))
+	packet, err := r.ReadPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, ShellV2Packet{ShellV2Stderr, []byte("hello")}, packet)
+}
+
+func TestShellV2ReadPacketIncompleteHeader(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader("\001\005\000"))
+	_, err := r.ReadPacket()
+	assert.Equal(t, errIncompleteMessage("shell packet header", 3, 5), err)
+}
+
+func TestShellV2ReadPacketIncompleteData(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader("\001\005\000\000\000hel"))
+	_, err := r.ReadPacket()
+	assert.Equal(t, errIncompleteMessage("shell packet data", 3, 5), err)
+}
+
+func TestShellV2ReadPacketTooLarge(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader("\001\377\377\377\377"))
+	_, err := r.ReadPacket()
+	assert.True(t, errors.HasErrCode(err, errors.ParseError))
+}
+
+func TestShellV2CopyOutput(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader(
+		"\001\003\000\000\000out\002\003\000\000\000err\001\001\000\000\000!\003\001\000\000\000\177"))
+	var stdout, stderr bytes.Buffer
+	exitCode, err := r.CopyOutput(&stdout, &stderr)
+	assert.NoError(t, err)
+	assert.Equal(t, 127, exitCode)
+	assert.Equal(t, "out!", stdout.String())
+	assert.Equal(t, "err", stderr.String())
+}
+
+func TestShellV2CopyOutputUnknownPacket(t *testing.T) {
+	r := NewShellV2Reader(strings.NewReader("\011\000\000\000\000"))
+	_, err := r.CopyOutput(nil, nil)
+	assert.Equal(t, errors.Errorf(errors.ParseError, "unknown shell packet ID: 9"), err)
+}
+
+// FuzzShellV2ReaderFraming encodes packets with ShellV2Writer and decodes them through a reader
+// that returns at most chunkSize bytes per read, checking nothing is lost or reordered.
+func FuzzShellV2ReaderFraming(f *testing.F) {
+	f.Add([]byte("hello"), []byte("world"), byte(0), byte(1))
+	f.Add([]byte{}, []byte("err"), byte(1), byte(4))
+	f.Add(bytes.Repeat([]byte{1, 3, 0, 0, 0}, 200), []byte{}, byte(255), byte(7))
+
+	f.Fuzz(func(t *testing.T, stdoutData, stderrData []byte, exitCode byte, chunkSize byte) {
+		var encoded bytes.Buffer
+		w := NewShellV2Writer(&encoded)
+		// Interleave the streams in small pieces to create many packet boundaries.
+		for i := 0; i < len(stdoutData) || i < len(stderrData); i += 3 {
+			if i < len(stdoutData) {
+				if err := w.WritePacket(ShellV2Stdout, stdoutData[i:min(i+3, len(stdoutData))]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if i < len(stderrData) {
+				if err := w.WritePacket(ShellV2Stderr, stderrData[i:min(i+3, len(stderrData))]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := w.WritePacket(ShellV2Exit, []byte{exitCode}); err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		r := NewShellV2Reader(&chunkedReader{&encoded, int(chunkSize%16) + 1})
+		gotExitCode, err := r.CopyOutput(&stdout, &stderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotExitCode != int(exitCode) {
+			t.Errorf("exit code: got %d, want %d", gotExitCode, exitCode)
+		}
+		if !bytes.Equal(stdout.Bytes(), stdoutData) {
+			t.Errorf("stdout: got %q, want %q", stdout.Bytes(), stdoutData)
+		}
+		if !bytes.Equal(stderr.Bytes(), stderrData) {
+			t.Errorf("stderr: got %q, want %q", stderr.Bytes(), stderrData)
+		}
+	})
+}
+
+// FuzzShellV2ReaderArbitraryInput checks that malformed streams produce errors instead of panics.
+func FuzzShellV2ReaderArbitraryInput(f *testing.F) {
+	f.Add([]byte("\001\005\000\000\000hello"))
+	f.Add([]byte("\001\377\377\377\377"))
+	f.Add([]byte("\003\000"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		NewShellV2Reader(bytes.NewReader(data)).CopyOutput(ioutil.Discard, ioutil.Discard)
+	})
+}
+
+// chunkedReader returns at most size bytes from each call to Read.
+type chunkedReader struct {
+	reader io.Reader
+	size   int
+}
+
+func (r *chunkedReader) Read(buf []byte) (int, error) {
+	if len(buf) > r.size {
+		buf = buf[:r.size]
+	}
+	return r.reader.Read(buf)
+}
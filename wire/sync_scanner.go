@@ -13,6 +13,7 @@ type SyncScanner interface {
 	io.Closer
 	StatusReader
 	ReadInt32() (int32, error)
+	ReadInt64() (int64, error)
 	ReadFileMode() (os.FileMode, error)
 	ReadTime() (time.Time, error)
 
@@ -41,6 +42,12 @@ func (s *realSyncScanner) ReadInt32() (int32, error) {
 	value, err := readInt32(s.Reader)
 	return int32(value), errors.WrapErrorf(err, errors.NetworkError, "error reading int from sync scanner")
 }
+func (s *realSyncScanner) ReadInt64() (int64, error) {
+	var value int64
+	err := binary.Read(s.Reader, binary.LittleEndian, &value)
+	return value, errors.WrapErrorf(err, errors.NetworkError, "error reading int64 from sync scanner")
+}
+
 func (s *realSyncScanner) ReadFileMode() (os.FileMode, error) {
 	var value uint32
 	err := binary.Read(s.Reader, binary.LittleEndian, &value)
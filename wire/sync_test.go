@@ -79,3 +79,10 @@ func TestSyncReadBytes(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "hello", string(str))
 }
+
+func TestSyncReadInt64(t *testing.T) {
+	s := NewSyncScanner(bytes.NewReader([]byte{0, 0, 0, 0, 1, 0, 0, 0}))
+	value, err := s.ReadInt64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1)<<32, value)
+}